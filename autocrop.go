@@ -0,0 +1,444 @@
+// autocrop.go
+package hpdevices
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"sort"
+)
+
+// Defaults of AutoCrop
+var (
+	DefaultAutoCropTolerance uint8 = 32
+	DefaultAutoCropGap             = Millimetres(5)
+	DefaultAutoCropMinSize         = Millimetres(15)
+)
+
+// autoCropDPI is the resolution used to find documents on the platen
+const autoCropDPI = 50
+
+// AutoCrop is a PageProcessor finding documents placed on the platen, like receipts,
+// ID cards or photos. The background is the color of the page borders, documents
+// are what differs from it. Each document is deskewed, and cropped with the padding.
+// Documents close to the background, like white receipts under a white lid, are
+// found by their content only.
+type AutoCrop struct {
+	Padding   Length // Kept around the documents
+	Tolerance uint8  // Gray levels from the background still counted as background, DefaultAutoCropTolerance when 0
+	Gap       Length // Parts of a document can be that far apart, DefaultAutoCropGap when 0
+	MinSize   Length // Smaller things are dust or shadows, DefaultAutoCropMinSize when 0
+	Split     bool   // Each document, like photos, is given as a page, from top to bottom
+	NoDeskew  bool   // Documents are cropped without being turned
+}
+
+func (a AutoCrop) ProcessPage(page Page) ([]Page, error) {
+	tolerance, gap, minSize := a.Tolerance, a.Gap, a.MinSize
+	if tolerance == 0 {
+		tolerance = DefaultAutoCropTolerance
+	}
+	if gap == 0 {
+		gap = DefaultAutoCropGap
+	}
+	if minSize == 0 {
+		minSize = DefaultAutoCropMinSize
+	}
+	xdpi, ydpi := page.Info.XResolution, page.Info.YResolution
+	if xdpi <= 0 || ydpi <= 0 {
+		xdpi, ydpi = 200, 200
+	}
+
+	small := newBlockImage(page.Image, xdpi/autoCropDPI, ydpi/autoCropDPI)
+	dpi := xdpi / small.fx // Of the blocks, autoCropDPI only when xdpi is a multiple of it
+	fg := small.foreground(tolerance)
+	radius := gap.Pixels(dpi) / 2
+	fg = fg.dilate(radius).erode(radius)
+	components := fg.components(minSize.Pixels(dpi))
+	if len(components) == 0 {
+		TRACE.Println("AutoCrop: no document found on page", page.Info.PageNumber)
+		return []Page{page}, nil
+	}
+	if !a.Split {
+		var all component
+		for _, c := range components {
+			all.merge(c)
+		}
+		components = []component{all}
+	}
+
+	var pages []Page
+	for i, c := range components {
+		r := minAreaRect(c.hull(small.fx, small.fy))
+		if a.NoDeskew {
+			r = boundingRect(c.hull(small.fx, small.fy))
+		}
+		p := page
+		p.Image = extractRect(page.Image, r, a.Padding.Pixels(xdpi), a.Padding.Pixels(ydpi))
+		p.Info.Skew = r.angle * 180 / math.Pi
+		if a.Split {
+			p.Info.Part = i + 1
+		}
+		pages = append(pages, p)
+	}
+	return pages, nil
+}
+
+// blockImage is the gray image reduced by averaging blocks of fx by fy pixels
+type blockImage struct {
+	pix           []uint8
+	width, height int
+	fx, fy        int
+}
+
+func newBlockImage(img image.Image, fx, fy int) *blockImage {
+	if fx < 1 {
+		fx = 1
+	}
+	if fy < 1 {
+		fy = 1
+	}
+	b := img.Bounds()
+	s := &blockImage{width: b.Dx() / fx, height: b.Dy() / fy, fx: fx, fy: fy}
+	s.pix = make([]uint8, s.width*s.height)
+	gray, isGray := img.(*image.Gray)
+	for y := 0; y < s.height; y++ {
+		for x := 0; x < s.width; x++ {
+			sum := 0
+			for dy := 0; dy < fy; dy++ {
+				for dx := 0; dx < fx; dx++ {
+					px, py := b.Min.X+x*fx+dx, b.Min.Y+y*fy+dy
+					if isGray {
+						sum += int(gray.Pix[gray.PixOffset(px, py)])
+					} else {
+						sum += int(color.GrayModel.Convert(img.At(px, py)).(color.Gray).Y)
+					}
+				}
+			}
+			s.pix[y*s.width+x] = uint8(sum / (fx * fy))
+		}
+	}
+	return s
+}
+
+// foreground marks what differs from the background, the median of the borders
+func (s *blockImage) foreground(tolerance uint8) *mask {
+	var histogram [256]int
+	count := 0
+	for y := 0; y < s.height; y++ {
+		for x := 0; x < s.width; x++ {
+			if x == 0 || y == 0 || x == s.width-1 || y == s.height-1 {
+				histogram[s.pix[y*s.width+x]]++
+				count++
+			}
+		}
+	}
+	background := 0
+	for seen := 0; background < 255; background++ {
+		seen += histogram[background]
+		if 2*seen >= count {
+			break
+		}
+	}
+	m := &mask{pix: make([]byte, len(s.pix)), width: s.width, height: s.height}
+	for i, v := range s.pix {
+		if d := int(v) - background; d > int(tolerance) || -d > int(tolerance) {
+			m.pix[i] = 1
+		}
+	}
+	return m
+}
+
+// dilate extends the mask by the radius, in a square
+func (m *mask) dilate(radius int) *mask {
+	return m.squareFilter(radius, 1)
+}
+
+// erode shrinks the mask by the radius, in a square
+func (m *mask) erode(radius int) *mask {
+	return m.squareFilter(radius, 0)
+}
+
+// squareFilter sets the pixels having a pixel of the value in the square around them
+func (m *mask) squareFilter(radius int, value byte) *mask {
+	if radius <= 0 {
+		return m
+	}
+	pass := func(src *mask, horizontal bool) *mask {
+		dst := &mask{pix: make([]byte, len(src.pix)), width: src.width, height: src.height}
+		for y := 0; y < src.height; y++ {
+			for x := 0; x < src.width; x++ {
+				v := 1 - value
+				for d := -radius; d <= radius && v != value; d++ {
+					sx, sy := x, y
+					if horizontal {
+						sx += d
+					} else {
+						sy += d
+					}
+					if sx >= 0 && sy >= 0 && sx < src.width && sy < src.height && src.pix[sy*src.width+sx] == value {
+						v = value
+					}
+				}
+				dst.pix[y*dst.width+x] = v
+			}
+		}
+		return dst
+	}
+	return pass(pass(m, true), false)
+}
+
+// component is a set of connected pixels, given by the extent of each row
+type component struct {
+	rows                   map[int][2]int // Row: first and last column
+	minX, minY, maxX, maxY int
+}
+
+func (c *component) add(x, y int) {
+	if c.rows == nil {
+		c.rows = map[int][2]int{}
+		c.minX, c.minY, c.maxX, c.maxY = x, y, x, y
+	}
+	r, ok := c.rows[y]
+	if !ok {
+		r = [2]int{x, x}
+	}
+	if x < r[0] {
+		r[0] = x
+	}
+	if x > r[1] {
+		r[1] = x
+	}
+	c.rows[y] = r
+	c.minX, c.maxX = minInt(c.minX, x), maxInt(c.maxX, x)
+	c.minY, c.maxY = minInt(c.minY, y), maxInt(c.maxY, y)
+}
+
+func (c *component) merge(o component) {
+	for y, r := range o.rows {
+		c.add(r[0], y)
+		c.add(r[1], y)
+	}
+}
+
+// components gives the groups of connected pixels of the mask, larger than minSize
+// in both directions, from top to bottom, and left to right
+func (m *mask) components(minSize int) []component {
+	label := make([]bool, len(m.pix))
+	var found []component
+	for start, v := range m.pix {
+		if v == 0 || label[start] {
+			continue
+		}
+		var c component
+		stack := []int{start}
+		label[start] = true
+		for len(stack) > 0 {
+			i := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			x, y := i%m.width, i/m.width
+			c.add(x, y)
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					nx, ny := x+dx, y+dy
+					if nx < 0 || ny < 0 || nx >= m.width || ny >= m.height {
+						continue
+					}
+					if n := ny*m.width + nx; m.pix[n] != 0 && !label[n] {
+						label[n] = true
+						stack = append(stack, n)
+					}
+				}
+			}
+		}
+		if c.maxX-c.minX+1 >= minSize && c.maxY-c.minY+1 >= minSize {
+			found = append(found, c)
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		if found[i].minY != found[j].minY {
+			return found[i].minY < found[j].minY
+		}
+		return found[i].minX < found[j].minX
+	})
+	return found
+}
+
+type point struct{ x, y float64 }
+
+// hull gives the convex hull of the component, in pixels of the page. Blocks on the
+// edges of a document are partly covered, their centers are closer to the edges than their corners.
+func (c *component) hull(fx, fy int) []point {
+	var points []point
+	for y, r := range c.rows {
+		cy := (float64(y) + 0.5) * float64(fy)
+		left, right := (float64(r[0])+0.5)*float64(fx), (float64(r[1])+0.5)*float64(fx)
+		points = append(points, point{left, cy}, point{right, cy})
+	}
+	return convexHull(points)
+}
+
+// convexHull uses the monotone chain algorithm
+func convexHull(points []point) []point {
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].x != points[j].x {
+			return points[i].x < points[j].x
+		}
+		return points[i].y < points[j].y
+	})
+	cross := func(o, a, b point) float64 {
+		return (a.x-o.x)*(b.y-o.y) - (a.y-o.y)*(b.x-o.x)
+	}
+	var hull []point
+	for _, p := range points {
+		for len(hull) >= 2 && cross(hull[len(hull)-2], hull[len(hull)-1], p) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, p)
+	}
+	lower := len(hull) + 1
+	for i := len(points) - 2; i >= 0; i-- {
+		p := points[i]
+		for len(hull) >= lower && cross(hull[len(hull)-2], hull[len(hull)-1], p) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, p)
+	}
+	return hull[:len(hull)-1]
+}
+
+// rotatedRect is a rectangle turned by the angle, in radians clockwise, around its center
+type rotatedRect struct {
+	center        point
+	width, height float64
+	angle         float64 // From -π/4 to π/4
+}
+
+// minAreaRect gives the rectangle of smallest area around the hull, one of its sides
+// being along a side of the hull
+func minAreaRect(hull []point) rotatedRect {
+	best, bestArea := boundingRect(hull), math.Inf(1)
+	for i := range hull {
+		a, b := hull[i], hull[(i+1)%len(hull)]
+		if a == b {
+			continue
+		}
+		angle := math.Atan2(b.y-a.y, b.x-a.x)
+		// Closest to the image axes
+		angle = math.Mod(angle, math.Pi/2)
+		if angle > math.Pi/4 {
+			angle -= math.Pi / 2
+		} else if angle <= -math.Pi/4 {
+			angle += math.Pi / 2
+		}
+		r := rectAlong(hull, angle)
+		if area := r.width * r.height; area < bestArea-1e-9 {
+			best, bestArea = r, area
+		}
+	}
+	return best
+}
+
+// boundingRect gives the rectangle around the hull, along the image axes
+func boundingRect(hull []point) rotatedRect {
+	return rectAlong(hull, 0)
+}
+
+// rectAlong gives the rectangle around the points, turned by the angle
+func rectAlong(points []point, angle float64) rotatedRect {
+	cos, sin := math.Cos(angle), math.Sin(angle)
+	minU, minV, maxU, maxV := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	for _, p := range points {
+		u, v := p.x*cos+p.y*sin, -p.x*sin+p.y*cos
+		minU, maxU = math.Min(minU, u), math.Max(maxU, u)
+		minV, maxV = math.Min(minV, v), math.Max(maxV, v)
+	}
+	cu, cv := (minU+maxU)/2, (minV+maxV)/2
+	return rotatedRect{
+		center: point{cu*cos - cv*sin, cu*sin + cv*cos},
+		width:  maxU - minU,
+		height: maxV - minV,
+		angle:  angle,
+	}
+}
+
+// extractRect gives the content of the rectangle, turned back along the image axes,
+// with the padding. What is outside of the image is white.
+func extractRect(img image.Image, r rotatedRect, padX, padY int) image.Image {
+	w := int(math.Round(r.width)) + 2*padX
+	h := int(math.Round(r.height)) + 2*padY
+	b := img.Bounds()
+	if math.Abs(r.angle) < 0.001 {
+		// Nothing to turn
+		min := image.Pt(int(math.Round(r.center.x-r.width/2))-padX, int(math.Round(r.center.y-r.height/2))-padY)
+		area := image.Rectangle{min, min.Add(image.Pt(w, h))}.Add(b.Min)
+		var dst draw.Image
+		if _, ok := img.(*image.Gray); ok {
+			dst = image.NewGray(image.Rect(0, 0, w, h))
+		} else {
+			dst = image.NewRGBA(image.Rect(0, 0, w, h))
+		}
+		draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+		draw.Draw(dst, area.Intersect(b).Sub(area.Min), img, area.Intersect(b).Min, draw.Src)
+		return dst
+	}
+
+	// Pixels of the source, with their channels
+	var src []uint8
+	var stride, channels int
+	var dst []uint8
+	var result image.Image
+	if g, ok := img.(*image.Gray); ok {
+		src, stride, channels = g.Pix[g.PixOffset(b.Min.X, b.Min.Y):], g.Stride, 1
+		d := image.NewGray(image.Rect(0, 0, w, h))
+		dst, result = d.Pix, d
+	} else {
+		rgba, ok := img.(*image.RGBA)
+		if !ok {
+			rgba = image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+			draw.Draw(rgba, rgba.Bounds(), img, b.Min, draw.Src)
+		}
+		min := rgba.Bounds().Min
+		src, stride, channels = rgba.Pix[rgba.PixOffset(min.X, min.Y):], rgba.Stride, 4
+		d := image.NewRGBA(image.Rect(0, 0, w, h))
+		dst, result = d.Pix, d
+	}
+
+	cos, sin := math.Cos(r.angle), math.Sin(r.angle)
+	at := func(x, y, c int) float64 {
+		if x < 0 || y < 0 || x >= b.Dx() || y >= b.Dy() {
+			return 255
+		}
+		return float64(src[y*stride+x*channels+c])
+	}
+	for j := 0; j < h; j++ {
+		for i := 0; i < w; i++ {
+			// Position in the rectangle, then in the source, from pixel centers
+			u, v := float64(i)+0.5-float64(w)/2, float64(j)+0.5-float64(h)/2
+			sx := r.center.x + u*cos - v*sin - 0.5
+			sy := r.center.y + u*sin + v*cos - 0.5
+			x0, y0 := int(math.Floor(sx)), int(math.Floor(sy))
+			ax, ay := sx-float64(x0), sy-float64(y0)
+			for c := 0; c < channels; c++ {
+				top := at(x0, y0, c)*(1-ax) + at(x0+1, y0, c)*ax
+				bottom := at(x0, y0+1, c)*(1-ax) + at(x0+1, y0+1, c)*ax
+				dst[(j*w+i)*channels+c] = uint8(math.Round(top*(1-ay) + bottom*ay))
+			}
+		}
+	}
+	return result
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,156 @@
+package hpdevices
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"math"
+	"testing"
+)
+
+// document is a white rectangle on the platen, turned clockwise by the angle, in degrees
+type document struct {
+	x, y, width, height, angle float64
+}
+
+// platen gives a dark platen at 200 dpi, with the documents
+func platen(documents ...document) *image.Gray {
+	page := image.NewGray(image.Rect(0, 0, 800, 1000))
+	draw.Draw(page, page.Bounds(), image.NewUniform(color.Gray{40}), image.Point{}, draw.Src)
+	for _, d := range documents {
+		cos, sin := math.Cos(d.angle*math.Pi/180), math.Sin(d.angle*math.Pi/180)
+		for y := 0; y < page.Rect.Dy(); y++ {
+			for x := 0; x < page.Rect.Dx(); x++ {
+				dx, dy := float64(x)+0.5-d.x, float64(y)+0.5-d.y
+				u, v := dx*cos+dy*sin, -dx*sin+dy*cos
+				if math.Abs(u) <= d.width/2 && math.Abs(v) <= d.height/2 {
+					page.Pix[y*page.Stride+x] = 250
+				}
+			}
+		}
+	}
+	return page
+}
+
+// whiteRatio gives the ratio of white pixels of the image, borders of a block aside
+func whiteRatio(img image.Image) float64 {
+	b := img.Bounds().Inset(200 / autoCropDPI)
+	white := 0
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y > 200 {
+				white++
+			}
+		}
+	}
+	return float64(white) / float64(b.Dx()*b.Dy())
+}
+
+func Test_AutoCropDeskew(t *testing.T) {
+	card := document{400, 450, 340, 220, 12}
+	page := Page{PageInfo{PageNumber: 1, XResolution: 200, YResolution: 200}, platen(card)}
+	pages, err := AutoCrop{}.ProcessPage(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 1 {
+		t.Fatalf("expected 1 page, got %d", len(pages))
+	}
+	p := pages[0]
+	b := p.Image.Bounds()
+	if math.Abs(p.Info.Skew-12) > 1 || math.Abs(float64(b.Dx())-card.width) > 8 || math.Abs(float64(b.Dy())-card.height) > 8 {
+		t.Errorf("unexpected crop %v, skew %.2f", b, p.Info.Skew)
+	}
+	if r := whiteRatio(p.Image); r < 0.99 {
+		t.Errorf("document not deskewed, %.3f white", r)
+	}
+
+	// Padding is kept around the document
+	pad := Millimetres(10).Pixels(200)
+	pages, _ = AutoCrop{Padding: Millimetres(10)}.ProcessPage(page)
+	if b := pages[0].Image.Bounds(); math.Abs(float64(b.Dx())-card.width-float64(2*pad)) > 8 {
+		t.Errorf("unexpected crop %v with padding of %d pixels", b, pad)
+	}
+
+	// Without deskew, the crop is the box around the turned document
+	pages, _ = AutoCrop{NoDeskew: true}.ProcessPage(page)
+	box := card.width*math.Cos(card.angle*math.Pi/180) + card.height*math.Sin(card.angle*math.Pi/180)
+	if b := pages[0].Image.Bounds(); pages[0].Info.Skew != 0 || math.Abs(float64(b.Dx())-box) > 8 {
+		t.Errorf("unexpected crop %v, skew %.2f", b, pages[0].Info.Skew)
+	}
+}
+
+func Test_AutoCropSplit(t *testing.T) {
+	photos := []document{
+		{200, 200, 240, 160, -5},
+		{550, 250, 180, 260, 3},
+		{400, 750, 400, 200, 0},
+	}
+	page := Page{PageInfo{PageNumber: 1, XResolution: 200, YResolution: 200}, platen(photos...)}
+	pages, err := AutoCrop{Split: true}.ProcessPage(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 3 {
+		t.Fatalf("expected 3 photos, got %d", len(pages))
+	}
+	for i, p := range pages {
+		b, photo := p.Image.Bounds(), photos[i]
+		if p.Info.Part != i+1 || math.Abs(p.Info.Skew-photo.angle) > 1 ||
+			math.Abs(float64(b.Dx())-photo.width) > 8 || math.Abs(float64(b.Dy())-photo.height) > 8 {
+			t.Errorf("photo %d: unexpected crop %v, %+v", i+1, b, p.Info)
+		}
+		if r := whiteRatio(p.Image); r < 0.99 {
+			t.Errorf("photo %d: %.3f white", i+1, r)
+		}
+	}
+
+	// Without split, the page holds all the photos
+	pages, _ = AutoCrop{}.ProcessPage(page)
+	if len(pages) != 1 || pages[0].Image.Bounds().Dy() < 700 {
+		t.Errorf("unexpected pages %d", len(pages))
+	}
+}
+
+func Test_AutoCropEmptyPlaten(t *testing.T) {
+	page := Page{PageInfo{PageNumber: 1, XResolution: 200, YResolution: 200}, platen()}
+	pages, err := AutoCrop{}.ProcessPage(page)
+	if err != nil || len(pages) != 1 || pages[0].Image != page.Image {
+		t.Errorf("empty platen modified: %v", err)
+	}
+}
+
+func Test_ScanAutoCrop(t *testing.T) {
+	fastPolling(t)
+	f := newFakeScanner(t, 1)
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, platen(document{400, 500, 300, 200, 8}), nil); err != nil {
+		t.Fatal(err)
+	}
+	f.pages[0] = b.Bytes()
+	f.height = 0 // Not checked against the page
+
+	w := new(memoryPageWriter)
+	if err := f.device().Scan(context.Background(), NewScanOptions(WithProcessors(AutoCrop{Split: true})), w); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.infos) != 1 || w.infos[0].Part != 1 || math.Abs(w.infos[0].Skew-8) > 1 || w.infos[0].Width > 320 {
+		t.Errorf("page not cropped: %+v", w.infos)
+	}
+}
+
+func Test_AutoCropBlockResolution(t *testing.T) {
+	// At 75 dpi, blocks are of one pixel, and the spot is under the minimum size
+	spot := document{600, 800, 36, 36, 0}
+	page := Page{PageInfo{PageNumber: 1, XResolution: 75, YResolution: 75}, platen(document{300, 300, 300, 200, 0}, spot)}
+	pages, err := AutoCrop{Split: true}.ProcessPage(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 1 {
+		t.Errorf("expected 1 document, got %d", len(pages))
+	}
+}
@@ -0,0 +1,188 @@
+// autorotate.go
+package hpdevices
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// DefaultAutoRotateConfidence is used by AutoRotate having no MinConfidence
+const DefaultAutoRotateConfidence = 0.2
+
+// AutoRotate is a PageProcessor turning upright pages of text. The direction of the
+// text lines tells if the page is on its side, and the letters going above the
+// lines, more frequent than the ones going below, tell if it's upside down.
+// Latin scripts are expected.
+type AutoRotate struct {
+	MinConfidence float64 // Pages are left as they are below, DefaultAutoRotateConfidence when 0
+}
+
+func (a AutoRotate) ProcessPage(page Page) ([]Page, error) {
+	min := a.MinConfidence
+	if min <= 0 {
+		min = DefaultAutoRotateConfidence
+	}
+	degrees, confidence := DetectTextRotation(page.Image)
+	if degrees == 0 || confidence < min {
+		return []Page{page}, nil
+	}
+	TRACE.Println("AutoRotate: page", page.Info.PageNumber, "turned by", degrees, "confidence", confidence)
+	page.Image = rotateImage(page.Image, degrees)
+	page.Info.Rotation = (page.Info.Rotation + degrees) % 360
+	return []Page{page}, nil
+}
+
+// inkThreshold separates ink from paper, on 8 bits gray levels
+const inkThreshold = 128
+
+// DetectTextRotation gives the clockwise rotation turning upright the text of the image,
+// with a confidence from 0 to 1. Images without text give a low confidence.
+func DetectTextRotation(img image.Image) (degrees int, confidence float64) {
+	ink := inkMask(img, inkThreshold)
+	rows, columns := ink.rowProfile(), ink.columnProfile()
+	horizontal, vertical := profileContrast(rows), profileContrast(columns)
+	if horizontal+vertical == 0 {
+		return 0, 0
+	}
+	axis := math.Abs(horizontal-vertical) / math.Max(horizontal, vertical)
+
+	if vertical > horizontal {
+		// Text lines go from bottom to top, or from top to bottom. Once
+		// turned by 90 degrees, the lines are upright or upside down.
+		rows = ink.turned().rowProfile()
+		degrees = 90
+	}
+	upright, updown := textUpright(rows)
+	if !upright {
+		degrees += 180
+	}
+	return degrees, math.Min(axis, updown)
+}
+
+// mask has one byte by pixel, 1 for ink
+type mask struct {
+	pix           []byte
+	width, height int
+}
+
+// inkMask gives the pixels darker than the level
+func inkMask(img image.Image, level uint8) *mask {
+	b := img.Bounds()
+	m := &mask{pix: make([]byte, b.Dx()*b.Dy()), width: b.Dx(), height: b.Dy()}
+	gray, isGray := img.(*image.Gray)
+	for y := 0; y < m.height; y++ {
+		for x := 0; x < m.width; x++ {
+			var v uint8
+			if isGray {
+				v = gray.Pix[gray.PixOffset(b.Min.X+x, b.Min.Y+y)]
+			} else {
+				v = color.GrayModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.Gray).Y
+			}
+			if v < level {
+				m.pix[y*m.width+x] = 1
+			}
+		}
+	}
+	return m
+}
+
+// turned gives the mask turned by 90 degrees clockwise
+func (m *mask) turned() *mask {
+	t := &mask{pix: make([]byte, len(m.pix)), width: m.height, height: m.width}
+	for y := 0; y < m.height; y++ {
+		for x := 0; x < m.width; x++ {
+			t.pix[x*t.width+m.height-1-y] = m.pix[y*m.width+x]
+		}
+	}
+	return t
+}
+
+func (m *mask) rowProfile() []int {
+	p := make([]int, m.height)
+	for y := range p {
+		for _, v := range m.pix[y*m.width : (y+1)*m.width] {
+			p[y] += int(v)
+		}
+	}
+	return p
+}
+
+func (m *mask) columnProfile() []int {
+	p := make([]int, m.width)
+	for y := 0; y < m.height; y++ {
+		for x, v := range m.pix[y*m.width : (y+1)*m.width] {
+			p[x] += int(v)
+		}
+	}
+	return p
+}
+
+// profileContrast is high when the profile alternates between lines of text and blank gaps
+func profileContrast(p []int) float64 {
+	var sum, variation float64
+	for i, v := range p {
+		sum += float64(v)
+		if i > 0 {
+			d := float64(v - p[i-1])
+			variation += d * d
+		}
+	}
+	if sum == 0 {
+		return 0
+	}
+	return variation / sum
+}
+
+// textUpright finds the lines of text in the row profile. In each line, the
+// core is where lower case letters are, and upright text has more ink above
+// the core, with the ascenders, than below, with the descenders.
+func textUpright(rows []int) (upright bool, confidence float64) {
+	peak := 0
+	for _, v := range rows {
+		if v > peak {
+			peak = v
+		}
+	}
+	noise := peak / 50
+	var above, below int
+	for y := 0; y < len(rows); {
+		if rows[y] <= noise {
+			y++
+			continue
+		}
+		top := y
+		for y < len(rows) && rows[y] > noise {
+			y++
+		}
+		line := rows[top:y]
+		if len(line) < 5 {
+			continue
+		}
+		linePeak := 0
+		for _, v := range line {
+			if v > linePeak {
+				linePeak = v
+			}
+		}
+		coreTop, coreBottom := -1, 0
+		for i, v := range line {
+			if 2*v >= linePeak {
+				if coreTop < 0 {
+					coreTop = i
+				}
+				coreBottom = i
+			}
+		}
+		for _, v := range line[:coreTop] {
+			above += v
+		}
+		for _, v := range line[coreBottom+1:] {
+			below += v
+		}
+	}
+	if above+below == 0 {
+		return true, 0
+	}
+	return above >= below, math.Abs(float64(above-below)) / float64(above+below)
+}
@@ -0,0 +1,55 @@
+package hpdevices
+
+import (
+	"image"
+	"image/draw"
+	"testing"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// textPage draws lines of text, 3 times the size of the basic font
+func textPage() *image.Gray {
+	lines := []string{
+		"The scanner gives each page to the writer",
+		"once the whole image has been downloaded,",
+		"and the device has reported the total number",
+		"of lines. Pages fed upside down through the",
+		"automatic document feeder are turned upright",
+		"according to the orientation given by the",
+		"device, or by looking at the text itself.",
+	}
+	small := image.NewGray(image.Rect(0, 0, 320, 20*len(lines)+20))
+	draw.Draw(small, small.Bounds(), image.White, image.Point{}, draw.Src)
+	d := font.Drawer{Dst: small, Src: image.Black, Face: basicfont.Face7x13}
+	for i, line := range lines {
+		d.Dot = fixed.P(10, 25+20*i)
+		d.DrawString(line)
+	}
+	page := image.NewGray(image.Rect(0, 0, 3*small.Rect.Dx(), 3*small.Rect.Dy()))
+	for y := 0; y < page.Rect.Dy(); y++ {
+		for x := 0; x < page.Rect.Dx(); x++ {
+			page.Pix[y*page.Stride+x] = small.Pix[(y/3)*small.Stride+x/3]
+		}
+	}
+	return page
+}
+
+func Test_DetectTextRotation(t *testing.T) {
+	page := textPage()
+	for _, turned := range []int{0, 90, 180, 270} {
+		degrees, confidence := DetectTextRotation(rotateImage(page, turned))
+		expected := (360 - turned) % 360
+		if degrees != expected || confidence < DefaultAutoRotateConfidence {
+			t.Errorf("page turned by %d: got rotation %d, confidence %.2f", turned, degrees, confidence)
+		}
+	}
+
+	blank := image.NewGray(image.Rect(0, 0, 100, 100))
+	draw.Draw(blank, blank.Bounds(), image.White, image.Point{}, draw.Src)
+	if _, confidence := DetectTextRotation(blank); confidence != 0 {
+		t.Errorf("confidence %.2f for a blank page", confidence)
+	}
+}
@@ -0,0 +1,88 @@
+// blank.go
+package hpdevices
+
+import (
+	"image"
+)
+
+// Defaults of BlankPageFilter
+var (
+	DefaultBlankThreshold = 0.001 // A line of text is above
+	DefaultBlankMargin    = Millimetres(10)
+)
+
+// BlankPageFilter is a PageProcessor finding blank pages, like the back sides of single sided
+// sheets scanned in duplex. The ink coverage is the ratio of pixels darker than the ink level,
+// margins aside, where the feeder leaves shadows and punch holes show. Isolated dark pixels,
+// like dust, aren't counted.
+type BlankPageFilter struct {
+	Threshold float64 // Coverage under which a page is blank, DefaultBlankThreshold when 0
+	Margin    Length  // Excluded on each side, DefaultBlankMargin when 0, none when negative
+	InkLevel  uint8   // Gray level under which a pixel is ink, 128 when 0
+	Keep      bool    // Blank pages are flagged with PageInfo.Blank instead of being dropped
+}
+
+func (f BlankPageFilter) ProcessPage(page Page) ([]Page, error) {
+	threshold := f.Threshold
+	if threshold <= 0 {
+		threshold = DefaultBlankThreshold
+	}
+	margin := f.Margin
+	if margin == 0 {
+		margin = DefaultBlankMargin
+	}
+	level := f.InkLevel
+	if level == 0 {
+		level = inkThreshold
+	}
+
+	area := page.Image.Bounds()
+	if margin > 0 {
+		mx, my := margin.Pixels(page.Info.XResolution), margin.Pixels(page.Info.YResolution)
+		area.Min.X, area.Max.X = area.Min.X+mx, area.Max.X-mx
+		area.Min.Y, area.Max.Y = area.Min.Y+my, area.Max.Y-my
+		if area.Empty() {
+			area = page.Image.Bounds() // Margins wider than the page, like a business card at low resolution
+		}
+	}
+	coverage := InkCoverage(page.Image, area, level)
+	page.Info.InkCoverage = coverage
+	if coverage >= threshold {
+		return []Page{page}, nil
+	}
+	if f.Keep {
+		page.Info.Blank = true
+		return []Page{page}, nil
+	}
+	INFO.Printf("BlankPageFilter: page %d dropped, ink coverage %.5f", page.Info.PageNumber, coverage)
+	return nil, nil
+}
+
+// InkCoverage gives the ratio of ink pixels in the area of the image, from 0 to 1.
+// A pixel is ink when darker than the level, and next to another ink pixel.
+func InkCoverage(img image.Image, area image.Rectangle, level uint8) float64 {
+	area = area.Intersect(img.Bounds())
+	if area.Empty() {
+		return 0
+	}
+	if sub, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		img = sub.SubImage(area)
+	}
+	ink := inkMask(img, level)
+	count := 0
+	for y := 0; y < ink.height; y++ {
+		for x := 0; x < ink.width; x++ {
+			i := y*ink.width + x
+			if ink.pix[i] == 0 {
+				continue
+			}
+			if (x+1 < ink.width && ink.pix[i+1] != 0) || (y+1 < ink.height && ink.pix[i+ink.width] != 0) ||
+				(x > 0 && ink.pix[i-1] != 0) || (y > 0 && ink.pix[i-ink.width] != 0) {
+				count++
+			}
+		}
+	}
+	return float64(count) / float64(ink.width*ink.height)
+}
@@ -0,0 +1,89 @@
+package hpdevices
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"testing"
+)
+
+// whitePage gives a white page at 200 dpi, with a dark band in the left margin, and dust
+func whitePage() *image.Gray {
+	page := image.NewGray(image.Rect(0, 0, 400, 600))
+	draw.Draw(page, page.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(page, image.Rect(0, 0, 60, 600), image.Black, image.Point{}, draw.Src)
+	for i := 0; i < 20; i++ {
+		page.Pix[(100+i*20)*page.Stride+100+i*10] = 0
+	}
+	return page
+}
+
+func Test_InkCoverage(t *testing.T) {
+	page := whitePage()
+	if c := InkCoverage(page, image.Rect(100, 0, 400, 600), inkThreshold); c != 0 {
+		t.Errorf("dust counted, coverage %f", c)
+	}
+	draw.Draw(page, image.Rect(200, 200, 210, 210), image.Black, image.Point{}, draw.Src)
+	if c := InkCoverage(page, image.Rect(100, 0, 400, 600), inkThreshold); c != 100.0/(300*600) {
+		t.Errorf("unexpected coverage %f", c)
+	}
+	if c := InkCoverage(page, page.Bounds(), inkThreshold); c < 0.15 {
+		t.Errorf("margin not counted, coverage %f", c)
+	}
+}
+
+func Test_BlankPageFilter(t *testing.T) {
+	blank := Page{PageInfo{PageNumber: 2, XResolution: 200, YResolution: 200}, whitePage()}
+	pages, err := BlankPageFilter{}.ProcessPage(blank)
+	if err != nil || len(pages) != 0 {
+		t.Errorf("blank page kept: %v", err)
+	}
+	pages, _ = BlankPageFilter{Keep: true}.ProcessPage(blank)
+	if len(pages) != 1 || !pages[0].Info.Blank || pages[0].Info.InkCoverage != 0 {
+		t.Errorf("blank page not flagged %+v", pages)
+	}
+	// Without margin, the band makes the page not blank
+	pages, _ = BlankPageFilter{Margin: -1}.ProcessPage(blank)
+	if len(pages) != 1 || pages[0].Info.Blank {
+		t.Errorf("margin not counted %+v", pages)
+	}
+
+	// Margins wider than the page are ignored
+	narrow := Page{PageInfo{PageNumber: 3, XResolution: 200, YResolution: 200}, textPage()}
+	pages, _ = BlankPageFilter{Margin: Millimetres(100)}.ProcessPage(narrow)
+	if len(pages) != 1 || pages[0].Info.InkCoverage == 0 {
+		t.Errorf("narrow page dropped %+v", pages)
+	}
+
+	text := Page{PageInfo{PageNumber: 1, XResolution: 200, YResolution: 200}, textPage()}
+	pages, _ = BlankPageFilter{}.ProcessPage(text)
+	if len(pages) != 1 || pages[0].Info.Blank || pages[0].Info.InkCoverage < DefaultBlankThreshold {
+		t.Errorf("page of text dropped %+v", pages)
+	}
+}
+
+func Test_ScanBlankBacks(t *testing.T) {
+	fastPolling(t)
+	f := newFakeScanner(t, 0)
+	for _, img := range []image.Image{textPage(), whitePage()} {
+		var b bytes.Buffer
+		if err := jpeg.Encode(&b, img, nil); err != nil {
+			t.Fatal(err)
+		}
+		f.pages = append(f.pages, b.Bytes())
+	}
+	f.height = 0 // Not checked against the pages
+
+	w := new(memoryPageWriter)
+	if err := f.device().Scan(context.Background(), NewScanOptions(WithProcessors(BlankPageFilter{})), w); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.infos) != 1 || w.infos[0].PageNumber != 1 {
+		t.Fatalf("blank back not dropped: %+v", w.infos)
+	}
+	if !bytes.Equal(w.pages[0].Bytes(), f.pages[0]) || w.infos[0].InkCoverage == 0 {
+		t.Errorf("kept page encoded again, or without its coverage %+v", w.infos[0])
+	}
+}
@@ -0,0 +1,153 @@
+// capabilities.go
+package hpdevices
+
+import (
+	"context"
+	"sort"
+)
+
+// InputSource is where the document is scanned from
+type InputSource string
+
+const (
+	SourcePlaten InputSource = "Platen"
+	SourceAdf    InputSource = "Adf"
+)
+
+// ScanCapabilities describes what the scanner can do, as told by /Scan/ScanCaps.
+// Sizes and margins are in device units, 1/300 of inch.
+type ScanCapabilities struct {
+	ModelName        string
+	DerivativeNumber int
+	ColorEntries     []ColorEntry
+	Sources          map[InputSource]*SourceCapabilities
+}
+
+// ColorEntry lists what is available for a color type
+type ColorEntry struct {
+	ColorType       string   // K1, Gray8, Color8
+	Formats         []string // Raw, Jpeg
+	ImageTransforms []string // ToneMap, Sharpening, NoiseRemoval
+	GrayRenderings  []string // NTSC, GrayCcdEmulated
+}
+
+// SourceCapabilities describes an input source
+type SourceCapabilities struct {
+	MinWidth              int
+	MinHeight             int
+	MaxWidth              int
+	MaxHeight             int
+	RiskyLeftMargin       int
+	RiskyRightMargin      int
+	RiskyTopMargin        int
+	RiskyBottomMargin     int
+	MinResolution         int
+	MaxOpticalXResolution int
+	MaxOpticalYResolution int
+	Resolutions           []Resolution
+	FeederCapacity        int      // Number of sheets, for feeders
+	AdfOptions            []string // DetectPaperLoaded, Duplex
+}
+
+// Resolution is a supported resolution, with the color types available at it
+type Resolution struct {
+	XResolution int
+	YResolution int
+	NumCcd      int
+	ColorTypes  []string
+}
+
+// ScanCapabilities asks the device for its scanner capabilities
+func (d *HPDevice) ScanCapabilities(ctx context.Context) (*ScanCapabilities, error) {
+	caps := new(scanCap)
+	err := d.getXML(ctx, d.endpoint(ctx, scanCapsResource), caps)
+	if err != nil {
+		return nil, NewHPDeviceError("HPDevice.ScanCapabilities", "", err)
+	}
+	return newScanCapabilities(caps), nil
+}
+
+func newScanCapabilities(caps *scanCap) *ScanCapabilities {
+	c := &ScanCapabilities{
+		ModelName:        caps.ModelName,
+		DerivativeNumber: caps.DerivativeNumber,
+		Sources:          make(map[InputSource]*SourceCapabilities),
+	}
+	for _, e := range caps.ColorEntries {
+		c.ColorEntries = append(c.ColorEntries, ColorEntry{
+			ColorType:       e.ColorType,
+			Formats:         e.Formats,
+			ImageTransforms: e.ImageTransforms,
+			GrayRenderings:  e.GrayRenderings,
+		})
+	}
+	for name, source := range caps.Sources {
+		sc := source.InputSourceCaps
+		s := &SourceCapabilities{
+			MinWidth:              sc.MinWidth,
+			MinHeight:             sc.MinHeight,
+			MaxWidth:              sc.MaxWidth,
+			MaxHeight:             sc.MaxHeight,
+			RiskyLeftMargin:       sc.RiskyLeftMargin,
+			RiskyRightMargin:      sc.RiskyRightMargin,
+			RiskyTopMargin:        sc.RiskyTopMargin,
+			RiskyBottomMargin:     sc.RiskyBottomMargin,
+			MinResolution:         sc.MinResolution,
+			MaxOpticalXResolution: sc.MaxOpticalXResolution,
+			MaxOpticalYResolution: sc.MaxOpticalYResolution,
+			FeederCapacity:        source.FeederCapacity,
+			AdfOptions:            source.AdfOptions,
+		}
+		for _, r := range sc.SupportedResolutions {
+			s.Resolutions = append(s.Resolutions, Resolution{
+				XResolution: r.XResolution,
+				YResolution: r.YResolution,
+				NumCcd:      r.NumCcd,
+				ColorTypes:  r.ColorTypes,
+			})
+		}
+		sort.Slice(s.Resolutions, func(i, j int) bool { return s.Resolutions[i].XResolution < s.Resolutions[j].XResolution })
+		c.Sources[InputSource(name)] = s
+	}
+	return c
+}
+
+// ColorEntry returns the entry of the color type
+func (c *ScanCapabilities) ColorEntry(colorType string) (*ColorEntry, bool) {
+	for i := range c.ColorEntries {
+		if c.ColorEntries[i].ColorType == colorType {
+			return &c.ColorEntries[i], true
+		}
+	}
+	return nil, false
+}
+
+// Source returns the capabilities of the input source
+func (c *ScanCapabilities) Source(source InputSource) (*SourceCapabilities, bool) {
+	s, ok := c.Sources[source]
+	return s, ok
+}
+
+// ResolutionsFor lists the resolutions supported for the color type, lowest first
+func (s *SourceCapabilities) ResolutionsFor(colorType string) (resolutions []Resolution) {
+	for _, r := range s.Resolutions {
+		if contains(r.ColorTypes, colorType) {
+			resolutions = append(resolutions, r)
+		}
+	}
+	return resolutions
+}
+
+// HasAdfOption tells if the feeder has the option, like Duplex
+func (s *SourceCapabilities) HasAdfOption(option string) bool {
+	return contains(s.AdfOptions, option)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package hpdevices
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const scanCapsXML = `<?xml version="1.0" encoding="UTF-8"?>
+<ScanCaps xmlns="http://www.hp.com/schemas/imaging/con/cnx/scan/2008/08/19" xmlns:dd="http://www.hp.com/schemas/imaging/con/dictionaries/1.0/">
+	<DeviceCaps><ModelName>HP Officejet Pro 8600</ModelName><DerivativeNumber>2</DerivativeNumber></DeviceCaps>
+	<ColorEntries>
+		<ColorEntry><ColorType>K1</ColorType><Formats><Format>Raw</Format></Formats><ImageTransforms><ImageTransform>Sharpening</ImageTransform></ImageTransforms></ColorEntry>
+		<ColorEntry><ColorType>Gray8</ColorType><Formats><Format>Raw</Format><Format>Jpeg</Format></Formats><ImageTransforms><ImageTransform>ToneMap</ImageTransform><ImageTransform>Sharpening</ImageTransform><ImageTransform>NoiseRemoval</ImageTransform></ImageTransforms><GrayRenderings><GrayRendering>NTSC</GrayRendering><GrayRendering>GrayCcdEmulated</GrayRendering></GrayRenderings></ColorEntry>
+		<ColorEntry><ColorType>Color8</ColorType><Formats><Format>Raw</Format><Format>Jpeg</Format></Formats><ImageTransforms><ImageTransform>ToneMap</ImageTransform><ImageTransform>Sharpening</ImageTransform><ImageTransform>NoiseRemoval</ImageTransform></ImageTransforms></ColorEntry>
+	</ColorEntries>
+	<Platen>
+		<InputSourceCaps>
+			<MinWidth>8</MinWidth><MinHeight>8</MinHeight><MaxWidth>2550</MaxWidth><MaxHeight>3508</MaxHeight>
+			<RiskyLeftMargin>50</RiskyLeftMargin><RiskyRightMargin>18</RiskyRightMargin><RiskyTopMargin>50</RiskyTopMargin><RiskyBottomMargin>48</RiskyBottomMargin>
+			<MinResolution>75</MinResolution><MaxOpticalXResolution>2400</MaxOpticalXResolution><MaxOpticalYResolution>2400</MaxOpticalYResolution>
+			<SupportedResolutions>
+				<Resolution><XResolution>300</XResolution><YResolution>300</YResolution><NumCcd>1</NumCcd><ColorTypes><ColorType>K1</ColorType><ColorType>Gray8</ColorType><ColorType>Color8</ColorType></ColorTypes></Resolution>
+				<Resolution><XResolution>75</XResolution><YResolution>75</YResolution><NumCcd>1</NumCcd><ColorTypes><ColorType>K1</ColorType><ColorType>Gray8</ColorType><ColorType>Color8</ColorType></ColorTypes></Resolution>
+				<Resolution><XResolution>200</XResolution><YResolution>200</YResolution><NumCcd>1</NumCcd><ColorTypes><ColorType>K1</ColorType><ColorType>Gray8</ColorType><ColorType>Color8</ColorType></ColorTypes></Resolution>
+				<Resolution><XResolution>1200</XResolution><YResolution>1200</YResolution><NumCcd>1</NumCcd><ColorTypes><ColorType>Gray8</ColorType><ColorType>Color8</ColorType></ColorTypes></Resolution>
+			</SupportedResolutions>
+		</InputSourceCaps>
+	</Platen>
+	<Adf>
+		<InputSourceCaps>
+			<MinWidth>8</MinWidth><MinHeight>8</MinHeight><MaxWidth>2550</MaxWidth><MaxHeight>4200</MaxHeight>
+			<RiskyLeftMargin>31</RiskyLeftMargin><RiskyRightMargin>18</RiskyRightMargin><RiskyTopMargin>37</RiskyTopMargin><RiskyBottomMargin>60</RiskyBottomMargin>
+			<MinResolution>75</MinResolution><MaxOpticalXResolution>300</MaxOpticalXResolution><MaxOpticalYResolution>300</MaxOpticalYResolution>
+			<SupportedResolutions>
+				<Resolution><XResolution>200</XResolution><YResolution>200</YResolution><NumCcd>1</NumCcd><ColorTypes><ColorType>K1</ColorType><ColorType>Gray8</ColorType><ColorType>Color8</ColorType></ColorTypes></Resolution>
+				<Resolution><XResolution>300</XResolution><YResolution>300</YResolution><NumCcd>1</NumCcd><ColorTypes><ColorType>K1</ColorType><ColorType>Gray8</ColorType><ColorType>Color8</ColorType></ColorTypes></Resolution>
+			</SupportedResolutions>
+		</InputSourceCaps>
+		<FeederCapacity>35</FeederCapacity>
+		<AdfOptions><AdfOption>DetectPaperLoaded</AdfOption><AdfOption>Duplex</AdfOption></AdfOptions>
+	</Adf>
+	<Camera>
+		<InputSourceCaps><MaxWidth>1600</MaxWidth><MaxHeight>1200</MaxHeight></InputSourceCaps>
+	</Camera>
+</ScanCaps>`
+
+func testScanCapabilities(t testing.TB) *ScanCapabilities {
+	d := &HPDevice{URL: "http://device", resources: &resourceCache{resources: new(DeviceResources)}}
+	d.Client = &http.Client{Transport: handlerTransport{http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, scanCapsXML)
+	})}}
+	caps, err := d.ScanCapabilities(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return caps
+}
+
+// handlerTransport serves requests with a handler, without network
+type handlerTransport struct {
+	handler http.Handler
+}
+
+func (h handlerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	w := httptest.NewRecorder()
+	h.handler.ServeHTTP(w, r)
+	resp := w.Result()
+	resp.Request = r
+	return resp, nil
+}
+
+func Test_ScanCapabilities(t *testing.T) {
+	caps := testScanCapabilities(t)
+	if caps.ModelName != "HP Officejet Pro 8600" || caps.DerivativeNumber != 2 || len(caps.ColorEntries) != 3 {
+		t.Fatalf("unexpected capabilities %+v", caps)
+	}
+	if e, ok := caps.ColorEntry("Gray8"); !ok || len(e.Formats) != 2 || len(e.GrayRenderings) != 2 {
+		t.Errorf("unexpected Gray8 entry %+v", e)
+	}
+	if len(caps.Sources) != 3 {
+		t.Fatalf("expected Platen, Adf and Camera sources, got %v", caps.Sources)
+	}
+
+	platen, ok := caps.Source(SourcePlaten)
+	if !ok || platen.MaxWidth != 2550 || platen.MaxHeight != 3508 || platen.RiskyLeftMargin != 50 {
+		t.Fatalf("unexpected platen %+v", platen)
+	}
+	if r := platen.ResolutionsFor("K1"); len(r) != 3 || r[0].XResolution != 75 || r[2].XResolution != 300 {
+		t.Errorf("unexpected K1 resolutions %+v", r)
+	}
+
+	adf, _ := caps.Source(SourceAdf)
+	if adf.FeederCapacity != 35 || !adf.HasAdfOption("Duplex") || platen.HasAdfOption("Duplex") {
+		t.Errorf("unexpected adf %+v", adf)
+	}
+	if camera, ok := caps.Source("Camera"); !ok || camera.MaxWidth != 1600 {
+		t.Errorf("unexpected camera %+v", camera)
+	}
+}
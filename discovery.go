@@ -0,0 +1,106 @@
+// discovery.go
+package hpdevices
+
+import (
+	"sync"
+	"time"
+)
+
+// Discoverer is implemented by the different ways of finding HP devices on the network.
+// Probe returns devices answering during the window. Identity fields may be
+// partially filled and the URL left empty, DiscoverDevices completes them.
+type Discoverer interface {
+	Probe(window time.Duration) ([]*HPDevice, error)
+}
+
+// DefaultDiscoverer is used by DiscoverDevices and the LocalizeDevice functions
+var DefaultDiscoverer Discoverer = MultiDiscoverer{SLPDiscoverer{}, &MDNSDiscoverer{}}
+
+// SLPDiscoverer finds devices with the Service Location Protocol
+type SLPDiscoverer struct{}
+
+func (SLPDiscoverer) Probe(window time.Duration) (devices []*HPDevice, err error) {
+	addresses, err := probeSLP(window)
+	if err != nil {
+		return nil, err
+	}
+	for _, address := range addresses {
+		devices = append(devices, &HPDevice{IPAddress: address})
+	}
+	return devices, nil
+}
+
+// MultiDiscoverer runs several discoverers at once and merges their results.
+// It fails only when all discoverers fail.
+type MultiDiscoverer []Discoverer
+
+func (m MultiDiscoverer) Probe(window time.Duration) (devices []*HPDevice, err error) {
+	results := make([][]*HPDevice, len(m))
+	errs := make([]error, len(m))
+	var wg sync.WaitGroup
+	for i, discoverer := range m {
+		wg.Add(1)
+		go func(i int, discoverer Discoverer) {
+			defer wg.Done()
+			results[i], errs[i] = discoverer.Probe(window)
+		}(i, discoverer)
+	}
+	wg.Wait()
+
+	failures := 0
+	for i := range m {
+		if errs[i] != nil {
+			TRACE.Println("MultiDiscoverer.Probe", errs[i])
+			failures++
+			err = errs[i]
+			continue
+		}
+		devices = mergeDevices(devices, results[i]...)
+	}
+	if failures > 0 && failures == len(m) {
+		return nil, err
+	}
+	return devices, nil
+}
+
+// mergeDevices adds found devices to the list. A device already in the list,
+// having the same UUID or the same address, is completed instead.
+func mergeDevices(devices []*HPDevice, found ...*HPDevice) []*HPDevice {
+	for _, f := range found {
+		merged := false
+		for _, d := range devices {
+			if sameDevice(d, f) {
+				d.complete(f)
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			devices = append(devices, f)
+		}
+	}
+	return devices
+}
+
+func sameDevice(a, b *HPDevice) bool {
+	if a.UUID != "" && b.UUID != "" {
+		return a.UUID == b.UUID
+	}
+	return a.IPAddress != "" && a.IPAddress == b.IPAddress
+}
+
+// complete fills the empty fields of the device with those of other
+func (d *HPDevice) complete(other *HPDevice) {
+	fill := func(field *string, value string) {
+		if *field == "" {
+			*field = value
+		}
+	}
+	fill(&d.URL, other.URL)
+	fill(&d.IPAddress, other.IPAddress)
+	fill(&d.HostName, other.HostName)
+	fill(&d.ModelName, other.ModelName)
+	fill(&d.SerialNumber, other.SerialNumber)
+	fill(&d.UUID, other.UUID)
+	fill(&d.ScanResource, other.ScanResource)
+}
@@ -0,0 +1,231 @@
+// download.go
+package hpdevices
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PageDownload tells how pages are downloaded. A page is kept until it's complete,
+// in memory up to MemoryLimit bytes, then in a temporary file. When the connection
+// is lost, the download continues from the last byte received if the device honours
+// HTTP Range requests, otherwise the page is requested again while the job is processing.
+type PageDownload struct {
+	Retries     int           // Downloads tried again for a page, DefaultPageDownload.Retries when 0, none when negative
+	RetryDelay  time.Duration // Wait before the first retry, doubled at each retry
+	MemoryLimit int64         // Size of a page kept in memory
+}
+
+// DefaultPageDownload is used by devices having no PageDownload
+var DefaultPageDownload = PageDownload{Retries: 3, RetryDelay: time.Second, MemoryLimit: 32 << 20}
+
+func (d *HPDevice) pageDownload() PageDownload {
+	p := d.PageDownload
+	switch {
+	case p.Retries == 0:
+		p.Retries = DefaultPageDownload.Retries
+	case p.Retries < 0:
+		p.Retries = 0
+	}
+	if p.RetryDelay <= 0 {
+		p.RetryDelay = DefaultPageDownload.RetryDelay
+	}
+	if p.MemoryLimit <= 0 {
+		p.MemoryLimit = DefaultPageDownload.MemoryLimit
+	}
+	return p
+}
+
+// download gets the whole page, retrying on network errors.
+// The caller closes the buffer.
+func (sj *hpscanJob) download(ctx context.Context, url string) (*pageBuffer, error) {
+	settings := sj.Device.pageDownload()
+	buf := &pageBuffer{limit: settings.MemoryLimit}
+	delay := settings.RetryDelay
+	for attempt := 1; ; attempt++ {
+		retry, err := sj.fetch(ctx, url, buf)
+		if err == nil {
+			return buf, nil
+		}
+		if !retry || attempt > settings.Retries || ctx.Err() != nil {
+			buf.Close()
+			return nil, err
+		}
+		TRACE.Println("ScanJob.download:", url, "interrupted after", buf.Len(), "bytes:", err)
+
+		select {
+		case <-ctx.Done():
+			buf.Close()
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+
+		// The page can only be requested again while the job goes on
+		j, jobErr := sj.getJob(ctx)
+		if jobErr != nil || j.JobState != "Processing" {
+			buf.Close()
+			return nil, err
+		}
+	}
+}
+
+// fetch gets the page from the end of the buffer. It tells if the error is worth a retry.
+func (sj *hpscanJob) fetch(ctx context.Context, url string, buf *pageBuffer) (retry bool, err error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return false, err
+	}
+	offset := buf.Len()
+	if offset > 0 {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+	}
+	resp, err := sj.Http.Do(req.WithContext(ctx))
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == 200:
+		if offset > 0 {
+			TRACE.Println("ScanJob.fetch: Range not honoured, page downloaded again", url)
+			if err = buf.Reset(); err != nil {
+				return false, err
+			}
+		}
+	case resp.StatusCode == 206 && offset > 0:
+		if start, ok := contentRangeStart(resp.Header.Get("Content-Range")); !ok || start != offset {
+			// Start again from the beginning
+			if err = buf.Reset(); err != nil {
+				return false, err
+			}
+			return true, HPDeviceError{"ScanJob.fetch", "Unexpected range " + resp.Header.Get("Content-Range"), nil}
+		}
+		TRACE.Println("ScanJob.fetch: resumed at", offset, url)
+	case resp.StatusCode == 416 && offset > 0:
+		if err = buf.Reset(); err != nil {
+			return false, err
+		}
+		return true, HPDeviceError{"ScanJob.fetch", "Range refused " + resp.Status, nil}
+	default:
+		return false, HPDeviceError{"ScanJob.fetch", "Unexpected status " + resp.Status, nil}
+	}
+
+	var body io.Reader = resp.Body
+	if sj.events != nil {
+		body = &progressReader{r: resp.Body, page: sj.page, events: sj.events, bytes: buf.Len(), reported: buf.Len()}
+	}
+	_, err = io.Copy(buf, body)
+	if buf.err != nil {
+		return false, buf.err
+	}
+	return err != nil, err
+}
+
+// contentRangeStart reads the first byte of a Content-Range like bytes 100-199/200
+func contentRangeStart(contentRange string) (int64, bool) {
+	if !strings.HasPrefix(contentRange, "bytes ") {
+		return 0, false
+	}
+	i := strings.Index(contentRange, "-")
+	if i < 0 {
+		return 0, false
+	}
+	start, err := strconv.ParseInt(strings.TrimPrefix(contentRange[:i], "bytes "), 10, 64)
+	return start, err == nil
+}
+
+// pageBuffer holds a page in memory up to limit bytes, then in a temporary file
+type pageBuffer struct {
+	limit int64
+	mem   bytes.Buffer
+	file  *os.File
+	size  int64
+	err   error // Write error, not worth a retry
+}
+
+func (b *pageBuffer) Write(p []byte) (n int, err error) {
+	if b.file == nil && b.size+int64(len(p)) > b.limit {
+		if err = b.spill(); err != nil {
+			b.err = err
+			return 0, err
+		}
+	}
+	if b.file != nil {
+		n, err = b.file.Write(p)
+	} else {
+		n, err = b.mem.Write(p)
+	}
+	b.size += int64(n)
+	if err != nil {
+		b.err = err
+	}
+	return n, err
+}
+
+// spill moves the buffer to a temporary file
+func (b *pageBuffer) spill() error {
+	f, err := ioutil.TempFile("", "hpdevices-page-")
+	if err != nil {
+		return err
+	}
+	TRACE.Println("pageBuffer: page kept in", f.Name())
+	b.file = f
+	if _, err = f.Write(b.mem.Bytes()); err != nil {
+		return err
+	}
+	b.mem = bytes.Buffer{}
+	return nil
+}
+
+// Len gives the number of bytes received
+func (b *pageBuffer) Len() int64 {
+	return b.size
+}
+
+// Reset drops what has been received
+func (b *pageBuffer) Reset() error {
+	b.size = 0
+	b.mem.Reset()
+	if b.file == nil {
+		return nil
+	}
+	if err := b.file.Truncate(0); err != nil {
+		return err
+	}
+	_, err := b.file.Seek(0, io.SeekStart)
+	return err
+}
+
+// Reader gives the page from its beginning
+func (b *pageBuffer) Reader() (io.Reader, error) {
+	if b.file == nil {
+		return bytes.NewReader(b.mem.Bytes()), nil
+	}
+	if _, err := b.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return io.LimitReader(b.file, b.size), nil
+}
+
+// Close removes the temporary file
+func (b *pageBuffer) Close() error {
+	if b.file == nil {
+		return nil
+	}
+	name := b.file.Name()
+	err := b.file.Close()
+	if err2 := os.Remove(name); err == nil {
+		err = err2
+	}
+	b.file = nil
+	return err
+}
@@ -0,0 +1,128 @@
+package hpdevices
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// serve runs the fake scanner on a network server, to lose connections
+func (f *fakeScanner) serve(t *testing.T) *HPDevice {
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	return &HPDevice{URL: server.URL, resources: &resourceCache{resources: &DeviceResources{}}}
+}
+
+func Test_DownloadResume(t *testing.T) {
+	fastPolling(t)
+	f := newFakeScanner(t, 2)
+	f.ranges, f.drops = true, 2
+	w := new(memoryPageWriter)
+	if err := f.serve(t).Scan(context.Background(), NewScanOptions(), w); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.pages) != 2 || !bytes.Equal(w.pages[0].Bytes(), f.pages[0]) || !bytes.Equal(w.pages[1].Bytes(), f.pages[1]) {
+		t.Fatalf("pages not complete")
+	}
+	// Half of the page, then half of the rest
+	first := len(f.pages[0]) / 2
+	second := first + (len(f.pages[0])-first)/2
+	expected := []string{"", "bytes=" + strconv.Itoa(first) + "-", "bytes=" + strconv.Itoa(second) + "-", ""}
+	if len(f.requests) != len(expected) {
+		t.Fatalf("unexpected requests %q", f.requests)
+	}
+	for i := range expected {
+		if f.requests[i] != expected[i] {
+			t.Errorf("request %d: got %q, expected %q", i, f.requests[i], expected[i])
+		}
+	}
+}
+
+func Test_DownloadRestart(t *testing.T) {
+	fastPolling(t)
+	f := newFakeScanner(t, 1)
+	f.drops = 1
+	w := new(memoryPageWriter)
+	if err := f.serve(t).Scan(context.Background(), NewScanOptions(), w); err != nil {
+		t.Fatal(err)
+	}
+	// The device ignores Range, the page is downloaded again
+	if len(f.requests) != 2 || f.requests[1] == "" {
+		t.Errorf("unexpected requests %q", f.requests)
+	}
+	if len(w.pages) != 1 || !bytes.Equal(w.pages[0].Bytes(), f.pages[0]) {
+		t.Errorf("page not complete")
+	}
+}
+
+func Test_DownloadGiveUp(t *testing.T) {
+	fastPolling(t)
+	f := newFakeScanner(t, 1)
+	f.ranges, f.drops = true, 10
+	w := new(memoryPageWriter)
+	d := f.serve(t)
+	d.PageDownload.Retries = 2
+	if err := d.Scan(context.Background(), NewScanOptions(), w); err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(f.requests) != 3 || len(w.pages) != 0 {
+		t.Errorf("%d requests, %d pages written", len(f.requests), len(w.pages))
+	}
+
+	// Without retries
+	f = newFakeScanner(t, 1)
+	f.ranges, f.drops = true, 10
+	d = f.serve(t)
+	d.PageDownload.Retries = -1
+	if err := d.Scan(context.Background(), NewScanOptions(), new(memoryPageWriter)); err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(f.requests) != 1 {
+		t.Errorf("%d requests without retries", len(f.requests))
+	}
+}
+
+func Test_PageBufferSpill(t *testing.T) {
+	dir := t.TempDir()
+	tmp := os.Getenv("TMPDIR")
+	os.Setenv("TMPDIR", dir)
+	defer os.Setenv("TMPDIR", tmp)
+
+	b := &pageBuffer{limit: 10}
+	b.Write([]byte("0123456"))
+	if b.file != nil {
+		t.Fatal("spilled under the limit")
+	}
+	b.Write([]byte("789abc"))
+	if b.file == nil || b.Len() != 13 {
+		t.Fatalf("not spilled, %d bytes", b.Len())
+	}
+	r, err := b.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadAll(r); string(data) != "0123456789abc" {
+		t.Errorf("got %q", data)
+	}
+
+	if err = b.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	b.Write([]byte("xyz"))
+	r, _ = b.Reader()
+	if data, _ := ioutil.ReadAll(r); string(data) != "xyz" {
+		t.Errorf("got %q after reset", data)
+	}
+
+	if err = b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 0 {
+		t.Errorf("temporary files left: %v", files)
+	}
+}
@@ -0,0 +1,77 @@
+// encode.go
+package hpdevices
+
+import (
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"golang.org/x/image/tiff"
+)
+
+// ImageEncoder is implemented by writers taking decoded pages instead of the
+// stream sent by the device. Raw and JPEG pages are decoded before being given to it.
+// Raw pages given to a plain PageWriter are encoded in PNG, and JPEG pages
+// that need to be rotated are encoded again in JPEG.
+type ImageEncoder interface {
+	EncodePage(info PageInfo, img image.Image) error
+}
+
+// PNGWriter encodes each page in PNG, in a writer given by the PageWriter
+type PNGWriter struct {
+	PageWriter
+}
+
+func (p PNGWriter) EncodePage(info PageInfo, img image.Image) error {
+	info.Format = "Png"
+	return encodePage(p.PageWriter, info, "PNGWriter", func(w io.Writer) error { return png.Encode(w, img) })
+}
+
+// JPEGWriter encodes each page in JPEG, in a writer given by the PageWriter
+type JPEGWriter struct {
+	PageWriter
+	Quality int // Default is jpeg.DefaultQuality
+}
+
+func (j JPEGWriter) EncodePage(info PageInfo, img image.Image) error {
+	info.Format = "Jpeg"
+	return encodePage(j.PageWriter, info, "JPEGWriter", func(w io.Writer) error {
+		quality := j.Quality
+		if quality == 0 {
+			quality = jpeg.DefaultQuality
+		}
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	})
+}
+
+// TIFFWriter encodes each page in TIFF, in a writer given by the PageWriter
+type TIFFWriter struct {
+	PageWriter
+	Compression tiff.CompressionType // Default is tiff.Uncompressed
+}
+
+func (t TIFFWriter) EncodePage(info PageInfo, img image.Image) error {
+	info.Format = "Tiff"
+	return encodePage(t.PageWriter, info, "TIFFWriter", func(w io.Writer) error {
+		return tiff.Encode(w, img, &tiff.Options{Compression: t.Compression})
+	})
+}
+
+// encodePage writes the image in a new page of pw
+func encodePage(pw PageWriter, info PageInfo, op string, encode func(w io.Writer) error) error {
+	w, err := pw.NewPage(info)
+	if err != nil {
+		return NewHPDeviceError(op, "NewPage", err)
+	}
+	err = encode(w)
+	if err != nil {
+		closeWithError(w, err)
+		return NewHPDeviceError(op, "Encode", err)
+	}
+	err = w.Close()
+	if err != nil {
+		return NewHPDeviceError(op, "Close", err)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+// endpoint.go
+package hpdevices
+
+import (
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Endpoint is a scheme and port where a device may serve its LEDM interface
+type Endpoint struct {
+	Scheme string
+	Port   int
+}
+
+// EndpointCandidates are tried in this order by NegotiateEndpoint
+var EndpointCandidates = []Endpoint{
+	{"http", 8080},
+	{"http", 80},
+	{"https", 443},
+}
+
+// EndpointTimeout limits the time spent on each candidate
+var EndpointTimeout = 3 * time.Second
+
+// baseURL builds the device URL, host can be a name, an IPv4 or an IPv6 address
+func baseURL(scheme, host string, port int) string {
+	u := url.URL{Scheme: scheme, Host: net.JoinHostPort(host, strconv.Itoa(port))}
+	return u.String()
+}
+
+// defaultURL is the URL used for a device before any negotiation
+func defaultURL(host string) string {
+	return baseURL(EndpointCandidates[0].Scheme, host, EndpointCandidates[0].Port)
+}
+
+// NewHPDeviceAt creates a device from its host name or address, and
+// negotiates the URL of its LEDM interface
+func NewHPDeviceAt(host string) (d *HPDevice, err error) {
+	d = &HPDevice{IPAddress: host}
+	err = d.NegotiateEndpoint()
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// NegotiateEndpoint tries each candidate endpoint of the device host, and keeps
+// the first one serving a discovery tree. Redirections, to HTTPS for instance, are followed.
+// Devices with a self signed certificate need a Client accepting it.
+func (d *HPDevice) NegotiateEndpoint() (err error) {
+	host := d.host()
+	if host == "" {
+		return NewHPDeviceError("HPDevice.NegotiateEndpoint", "No host known for the device", nil)
+	}
+	for _, candidate := range EndpointCandidates {
+		base := baseURL(candidate.Scheme, host, candidate.Port)
+		ctx, cancel := context.WithTimeout(context.Background(), EndpointTimeout)
+		var working string
+		working, err = d.tryEndpoint(ctx, base)
+		cancel()
+		if err == nil {
+			TRACE.Println("HPDevice.NegotiateEndpoint", host, "served at", working)
+			d.URL = working
+			return nil
+		}
+		TRACE.Println("HPDevice.NegotiateEndpoint", base, err)
+	}
+	return NewHPDeviceError("HPDevice.NegotiateEndpoint", "No working endpoint for "+host, err)
+}
+
+// tryEndpoint fetches the discovery tree and returns the base URL it has been served from
+func (d *HPDevice) tryEndpoint(ctx context.Context, base string) (string, error) {
+	req, err := http.NewRequest("GET", base+"/DevMgmt/DiscoveryTree.xml", nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := d.client().Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		// Not logged, most tried endpoints are expected to fail
+		return "", HPDeviceError{"HPDevice.tryEndpoint", "Unexpected status " + resp.Status, nil}
+	}
+	buffer, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	err = xml.Unmarshal(buffer, new(discoveryTree))
+	if err != nil {
+		return "", HPDeviceError{"HPDevice.tryEndpoint", "Not a discovery tree", err}
+	}
+	// The last request is the one after redirections
+	final := resp.Request.URL
+	return (&url.URL{Scheme: final.Scheme, Host: final.Host}).String(), nil
+}
+
+// host returns the device address, or the host of its URL
+func (d *HPDevice) host() string {
+	if d.IPAddress != "" {
+		return d.IPAddress
+	}
+	if u, err := url.Parse(d.URL); err == nil {
+		return u.Hostname()
+	}
+	return ""
+}
+
+// client returns the HTTP client used to talk with the device
+func (d *HPDevice) client() *http.Client {
+	if d.Client != nil {
+		return d.Client
+	}
+	return http.DefaultClient
+}
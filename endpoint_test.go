@@ -0,0 +1,64 @@
+package hpdevices
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const discoveryTreeXML = `<?xml version="1.0" encoding="UTF-8"?>
+<ledm:DiscoveryTree xmlns:ledm="http://www.hp.com/schemas/imaging/con/ledm/2007/09/21" xmlns:dd="http://www.hp.com/schemas/imaging/con/dictionaries/1.0/">
+	<dd:Version><dd:Revision>SVN-IPG-LEDM.216</dd:Revision><dd:Date>2011-02-08</dd:Date></dd:Version>
+	<ledm:SupportedTree><dd:ResourceURI>/DevMgmt/ProductConfigDyn.xml</dd:ResourceURI><dd:ResourceType>ledm:hpLedmProductConfigDyn</dd:ResourceType></ledm:SupportedTree>
+	<ledm:SupportedIfc><ledm:ManifestURI>/Scan/ScanManifest.xml</ledm:ManifestURI><dd:ResourceType>ledm:hpCnxScanManifest</dd:ResourceType></ledm:SupportedIfc>
+</ledm:DiscoveryTree>`
+
+func Test_BaseURL(t *testing.T) {
+	tests := []struct {
+		scheme, host string
+		port         int
+		want         string
+	}{
+		{"http", "192.168.1.42", 8080, "http://192.168.1.42:8080"},
+		{"https", "fe80::1", 443, "https://[fe80::1]:443"},
+		{"http", "hp8f3e21.local", 80, "http://hp8f3e21.local:80"},
+	}
+	for _, test := range tests {
+		if got := baseURL(test.scheme, test.host, test.port); got != test.want {
+			t.Errorf("baseURL(%s, %s, %d) = %s, want %s", test.scheme, test.host, test.port, got, test.want)
+		}
+	}
+}
+
+func Test_TryEndpoint(t *testing.T) {
+	ledm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, discoveryTreeXML)
+	}))
+	defer ledm.Close()
+	redirect := httptest.NewServer(http.RedirectHandler(ledm.URL+"/DevMgmt/DiscoveryTree.xml", http.StatusMovedPermanently))
+	defer redirect.Close()
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<html></html>")
+	}))
+	defer other.Close()
+
+	d := new(HPDevice)
+	if base, err := d.tryEndpoint(context.Background(), redirect.URL); err != nil || base != ledm.URL {
+		t.Errorf("tryEndpoint got %s, %v, want %s", base, err, ledm.URL)
+	}
+	if _, err := d.tryEndpoint(context.Background(), other.URL); err == nil {
+		t.Error("tryEndpoint accepted a server without discovery tree")
+	}
+}
+
+func Test_Resolve(t *testing.T) {
+	d := &HPDevice{URL: "https://[fe80::1]:443"}
+	if got := d.resolve("/Scan/Jobs/12"); got != "https://[fe80::1]:443/Scan/Jobs/12" {
+		t.Errorf("resolve relative got %s", got)
+	}
+	if got := d.resolve("http://10.0.0.1:8080/Scan/Jobs/12"); got != "http://10.0.0.1:8080/Scan/Jobs/12" {
+		t.Errorf("resolve absolute got %s", got)
+	}
+}
@@ -0,0 +1,150 @@
+// exif.go
+package hpdevices
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// exifOrientation gives the EXIF orientation tag of an image to be displayed
+// turned by the clockwise rotation
+func exifOrientation(rotation int) int {
+	switch rotation {
+	case 90:
+		return 6
+	case 180:
+		return 3
+	case 270:
+		return 8
+	}
+	return 1
+}
+
+// exifOrientationTag is the TIFF tag of the orientation
+const exifOrientationTag = 0x0112
+
+// withEXIFOrientation gives the JPEG stream with the orientation tag. An EXIF segment
+// is updated, otherwise one is added, after the JFIF header if any.
+func withEXIFOrientation(r io.Reader, orientation int) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(2)
+	if err != nil || head[0] != 0xFF || head[1] != 0xD8 {
+		return nil, errors.New("withEXIFOrientation: not a JPEG stream")
+	}
+	var b bytes.Buffer
+	if _, err = io.CopyN(&b, br, 2); err != nil {
+		return nil, err
+	}
+
+	// Application segments, where the EXIF segment is
+	insert := b.Len()
+	for {
+		marker, err := br.Peek(2)
+		if err != nil || marker[0] != 0xFF || marker[1] < 0xE0 || marker[1] > 0xEF {
+			break
+		}
+		kind := marker[1]
+		if marker, err = br.Peek(4); err != nil {
+			return nil, err
+		}
+		length := int(marker[2])<<8 + int(marker[3])
+		if length < 2 {
+			return nil, errors.New("withEXIFOrientation: invalid segment length")
+		}
+		segment := make([]byte, 2+length)
+		if _, err = io.ReadFull(br, segment); err != nil {
+			return nil, err
+		}
+		if kind == 0xE1 && bytes.HasPrefix(segment[4:], []byte("Exif\x00\x00")) {
+			if segment, err = setEXIFOrientation(segment, orientation); err != nil {
+				return nil, err
+			}
+			b.Write(segment)
+			return io.MultiReader(&b, br), nil
+		}
+		if kind == 0xE0 && b.Len() == 2 {
+			insert = 2 + len(segment)
+		}
+		b.Write(segment)
+	}
+
+	segment := []byte{
+		0xFF, 0xE1, 0, 34, // APP1, length
+		'E', 'x', 'i', 'f', 0, 0,
+		'M', 'M', 0, 42, 0, 0, 0, 8, // Big endian TIFF header, IFD at 8
+		0, 1, // One entry
+		0x01, 0x12, 0, 3, 0, 0, 0, 1, 0, byte(orientation), 0, 0, // Orientation, SHORT, 1 value
+		0, 0, 0, 0, // No next IFD
+	}
+	head = b.Bytes()
+	return io.MultiReader(bytes.NewReader(head[:insert]), bytes.NewReader(segment), bytes.NewReader(head[insert:]), br), nil
+}
+
+// setEXIFOrientation sets the orientation tag in the first IFD of the EXIF segment.
+// When the IFD has no orientation, a copy of it with the tag is added at the end
+// of the segment, the values of the other tags don't move.
+func setEXIFOrientation(segment []byte, orientation int) ([]byte, error) {
+	invalid := errors.New("setEXIFOrientation: invalid EXIF segment")
+	tiff := segment[10:]
+	if len(tiff) < 8 {
+		return nil, invalid
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "MM":
+		order = binary.BigEndian
+	case "II":
+		order = binary.LittleEndian
+	default:
+		return nil, invalid
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return nil, invalid
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	entries := tiff[ifd+2:]
+	if len(entries) < 12*count+4 {
+		return nil, invalid
+	}
+	entry := func(e []byte) {
+		order.PutUint16(e, exifOrientationTag)
+		order.PutUint16(e[2:], 3) // SHORT
+		order.PutUint32(e[4:], 1)
+		order.PutUint16(e[8:], uint16(orientation))
+		e[10], e[11] = 0, 0
+	}
+
+	at := count // Position of the orientation in the IFD, entries are sorted by tag
+	for i := 0; i < count; i++ {
+		tag := order.Uint16(entries[12*i:])
+		if tag == exifOrientationTag {
+			entry(entries[12*i:])
+			return segment, nil
+		}
+		if tag > exifOrientationTag && at == count {
+			at = i
+		}
+	}
+
+	out := append([]byte{}, segment...)
+	if len(out)%2 != 0 {
+		out = append(out, 0) // IFDs start on a word boundary
+	}
+	newIFD := len(out) - 10
+	out = append(out, 0, 0)
+	order.PutUint16(out[len(out)-2:], uint16(count+1))
+	out = append(out, entries[:12*at]...)
+	out = append(out, make([]byte, 12)...)
+	entry(out[len(out)-12:])
+	out = append(out, entries[12*at:12*count+4]...) // Following entries, and next IFD
+	order.PutUint32(out[10+4:], uint32(newIFD))
+	if len(out)-2 > 0xFFFF {
+		return nil, errors.New("setEXIFOrientation: EXIF segment too large")
+	}
+	out[2], out[3] = byte((len(out)-2)>>8), byte(len(out)-2)
+	return out, nil
+}
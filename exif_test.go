@@ -0,0 +1,84 @@
+package hpdevices
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+)
+
+func Test_WithEXIFOrientation(t *testing.T) {
+	jfif := []byte{0xFF, 0xD8, 0xFF, 0xE0, 0, 4, 'J', 'F', 0xFF, 0xDB}
+	r, err := withEXIFOrientation(bytes.NewReader(jfif), exifOrientation(270))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _ := ioutil.ReadAll(r)
+	if !bytes.Equal(out[:8], jfif[:8]) || out[8] != 0xFF || out[9] != 0xE1 || out[8+29] != 8 || !bytes.Equal(out[8+36:], jfif[8:]) {
+		t.Errorf("unexpected stream % x", out)
+	}
+	if _, err = withEXIFOrientation(bytes.NewReader([]byte("\x89PNG\r\n")), 3); err == nil {
+		t.Error("PNG stream accepted")
+	}
+}
+
+// exifStream gives a JPEG stream start with a little endian EXIF segment having the entries
+func exifStream(entries ...[12]byte) []byte {
+	tiff := []byte{'I', 'I', 42, 0, 8, 0, 0, 0, byte(len(entries)), 0}
+	for _, e := range entries {
+		tiff = append(tiff, e[:]...)
+	}
+	tiff = append(tiff, 0, 0, 0, 0, 'H', 'P', 0, 0) // No next IFD, then a value
+	segment := append([]byte{0xFF, 0xE1, 0, byte(8 + len(tiff)), 'E', 'x', 'i', 'f', 0, 0}, tiff...)
+	return append(append([]byte{0xFF, 0xD8}, segment...), 0xFF, 0xDB)
+}
+
+// ifdEntries reads the first IFD of the EXIF segment following SOI
+func ifdEntries(t *testing.T, stream []byte) (entries [][12]byte) {
+	t.Helper()
+	if stream[2] != 0xFF || stream[3] != 0xE1 || string(stream[6:10]) != "Exif" {
+		t.Fatalf("no EXIF segment % x", stream)
+	}
+	length := int(stream[4])<<8 + int(stream[5])
+	if stream[4+length] != 0xFF || stream[5+length] != 0xDB {
+		t.Fatalf("bad segment length %d", length)
+	}
+	tiff := stream[12 : 4+length]
+	ifd := int(binary.LittleEndian.Uint32(tiff[4:]))
+	for i := 0; i < int(binary.LittleEndian.Uint16(tiff[ifd:])); i++ {
+		var e [12]byte
+		copy(e[:], tiff[ifd+2+12*i:])
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func Test_UpdateEXIFOrientation(t *testing.T) {
+	orientation := [12]byte{0x12, 0x01, 3, 0, 1, 0, 0, 0, 1, 0, 0, 0}
+	maker := [12]byte{0x0F, 0x01, 2, 0, 3, 0, 0, 0, 'H', 'P', 0, 0}
+	software := [12]byte{0x31, 0x01, 2, 0, 3, 0, 0, 0, 'F', 'W', 0, 0}
+
+	// The tag is updated in place
+	in := exifStream(maker, orientation)
+	r, err := withEXIFOrientation(bytes.NewReader(in), 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _ := ioutil.ReadAll(r)
+	entries := ifdEntries(t, out)
+	if len(out) != len(in) || len(entries) != 2 || entries[0] != maker || entries[1][8] != 6 {
+		t.Errorf("orientation not updated % x", out)
+	}
+
+	// The tag is added, between the other ones
+	r, err = withEXIFOrientation(bytes.NewReader(exifStream(maker, software)), 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _ = ioutil.ReadAll(r)
+	entries = ifdEntries(t, out)
+	orientation[8] = 8
+	if len(entries) != 3 || entries[0] != maker || entries[1] != orientation || entries[2] != software {
+		t.Errorf("orientation not added % x", out)
+	}
+}
@@ -3,6 +3,7 @@ package hpdevices
 
 import (
 	"encoding/xml"
+	"strings"
 )
 
 const xmlHeader = `<?xml version="1.0" encoding="utf-8"?>`
@@ -31,6 +32,41 @@ type supportedIfc struct {
 	Revision     string   `xml:"http://www.hp.com/schemas/imaging/con/dictionaries/1.0/ Revision"`
 }
 
+// Manifest namespaces change with firmware generations, so they aren't checked
+type manifest struct {
+	XMLName      xml.Name      `xml:"Manifest"`
+	ResourceMaps []resourceMap `xml:"ResourceMap"`
+}
+
+type resourceMap struct {
+	ResourceLink string             `xml:"ResourceLink>ResourceURI"`
+	Resources    []manifestResource `xml:"Resources>Resource"`
+}
+
+type manifestResource struct {
+	ResourceURI  string               `xml:"ResourceURI>ResourceURI"`
+	ResourceType manifestResourceType `xml:"ResourceType"`
+}
+
+type manifestResourceType struct {
+	Typed string `xml:",any"`      // <scan:ScanResourceType>ScanCaps</scan:ScanResourceType>
+	Text  string `xml:",chardata"` // ScanCaps
+}
+
+func (t manifestResourceType) value() string {
+	if t.Typed != "" {
+		return strings.TrimSpace(t.Typed)
+	}
+	return strings.TrimSpace(t.Text)
+}
+
+type productConfigDyn struct {
+	XMLName      xml.Name `xml:"ProductConfigDyn"`
+	MakeAndModel string   `xml:"ProductInformation>MakeAndModel"`
+	SerialNumber string   `xml:"ProductInformation>SerialNumber"`
+	UUID         string   `xml:"ProductInformation>UUID"`
+}
+
 type walkupScanToCompDestinations struct {
 	XMLName                      xml.Name                      `xml:"http://www.hp.com/schemas/imaging/con/ledm/walkupscan/2010/09/28 WalkupScanToCompDestinations"`
 	WalkupScanToCompDestinations []walkupScanToCompDestination `xml:"WalkupScanToCompDestination"`
@@ -97,6 +133,7 @@ type scanSettings struct {
 	ColorSpace         string
 	BitDepth           int
 	InputSource        string
+	AdfOptions         []string `xml:"AdfOptions>AdfOption,omitempty"` // Duplex
 	GrayRendering      string
 	ToneMap            toneMap
 	SharpeningLevel    int
@@ -116,13 +153,52 @@ type toneMap struct {
 }
 
 type scanCap struct {
-	XMLName          xml.Name     `xml:"http://www.hp.com/schemas/imaging/con/cnx/scan/2008/08/19 ScanCaps"`
-	ModelName        string       `xml:"DeviceCaps>ModelName"`
-	DerivativeNumber int          `xml:"DeviceCaps>DerivativeNumber"`
-	ColorEntries     []colorEntry `xml:"ColorEntries>ColorEntry"`
-	//TODO: Change following fields by a map
-	Platen scanSource `xml:"Platen"`
-	Adf    scanSource `xml:"Adf"`
+	XMLName          xml.Name
+	ModelName        string
+	DerivativeNumber int
+	ColorEntries     []colorEntry
+	Sources          map[string]scanSource // Platen, Adf, and any other element having InputSourceCaps
+}
+
+// UnmarshalXML collects every input source in the Sources map
+func (c *scanCap) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	c.XMLName = start.Name
+	c.Sources = make(map[string]scanSource)
+	for {
+		token, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "DeviceCaps":
+				var caps struct {
+					ModelName        string
+					DerivativeNumber int
+				}
+				err = d.DecodeElement(&caps, &t)
+				c.ModelName, c.DerivativeNumber = caps.ModelName, caps.DerivativeNumber
+			case "ColorEntries":
+				var entries struct {
+					ColorEntries []colorEntry `xml:"ColorEntry"`
+				}
+				err = d.DecodeElement(&entries, &t)
+				c.ColorEntries = entries.ColorEntries
+			default:
+				var source scanSource
+				err = d.DecodeElement(&source, &t)
+				if source.InputSourceCaps.MaxWidth > 0 || len(source.InputSourceCaps.SupportedResolutions) > 0 {
+					c.Sources[t.Name.Local] = source
+				}
+			}
+			if err != nil {
+				return err
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
 }
 
 type colorEntry struct {
@@ -182,6 +258,12 @@ type job struct {
 	ScanJob        scanJob `xml:"http://www.hp.com/schemas/imaging/con/cnx/scan/2008/08/19 ScanJob"`
 }
 
+// jobStateUpdate is put to the job URL to change its state, like Canceled
+type jobStateUpdate struct {
+	XMLName  xml.Name `xml:"http://www.hp.com/schemas/imaging/con/ledm/jobs/2009/04/30 Job"`
+	JobState string
+}
+
 type scanJob struct {
 	XMLName      xml.Name `xml:"http://www.hp.com/schemas/imaging/con/cnx/scan/2008/08/19 ScanJob"`
 	PreScanPage  *preScanPage
@@ -0,0 +1,143 @@
+// integrity.go
+package hpdevices
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/simulot/hpdevices/jpegfix"
+)
+
+// ErrPageIncomplete is the error of a page failing the integrity checks
+var ErrPageIncomplete = errors.New("Page incomplete")
+
+// PageAction tells what to do with a page failing the integrity checks
+type PageAction int
+
+const (
+	AbortJob  PageAction = iota // The job fails, this is the default
+	RetryPage                   // The page is downloaded again, up to PageDownload.Retries times
+	SkipPage                    // The page isn't given to the writer, the job goes on
+)
+
+// PageFailure describes a page failing the integrity checks
+type PageFailure struct {
+	Info    PageInfo
+	Result  PageResult // TotalLines is 0 when the device hasn't given it
+	Attempt int        // 1 for the first download
+	Err     error
+}
+
+// PageFailureHandler can be implemented by page writers to choose what to do with a page
+// failing the integrity checks. JPEG pages must end with an EOI marker, and the lines received
+// must match the ImageHeight announced and the TotalLines reported by the device. Pages are
+// checked once downloaded, and again when the device reports the page is finished, before being
+// given to the writer. A page can be downloaded again as long as the device keeps it.
+// Without handler, the job is aborted.
+//
+// The lines of JPEG pages are taken from their frame header. The feeders give
+// jpegfix.UnknownLines there: only the EOI marker of these pages is checked.
+// TotalLines is 0 when the PostScanPage of a page is missed, the next page being ready first.
+type PageFailureHandler interface {
+	PageFailed(failure PageFailure) PageAction
+}
+
+// pageFailureHandler finds the handler of a writer, through the writers given by the package
+func pageFailureHandler(w PageWriter) (PageFailureHandler, bool) {
+	for {
+		if h, ok := w.(PageFailureHandler); ok {
+			return h, true
+		}
+		switch writer := w.(type) {
+		case imageWriterPages:
+			h, ok := writer.ImageWriter.(PageFailureHandler)
+			return h, ok
+		case PNGWriter:
+			w = writer.PageWriter
+		case JPEGWriter:
+			w = writer.PageWriter
+		case TIFFWriter:
+			w = writer.PageWriter
+		default:
+			return nil, false
+		}
+	}
+}
+
+// pendingPage is a page downloaded, waiting for its PostScanPage to be checked and written
+type pendingPage struct {
+	url  string
+	info PageInfo
+	buf  *pageBuffer
+}
+
+// check verifies the page is complete. totalLines is 0 when unknown.
+func (p *pendingPage) check(totalLines int) error {
+	size := p.buf.Len()
+	if size == 0 {
+		return p.failed("empty page")
+	}
+
+	// Lines received, 0 when the stream doesn't tell
+	lines := 0
+	switch p.info.Format {
+	case "Raw":
+		if p.info.BytesPerLine > 0 {
+			if size%int64(p.info.BytesPerLine) != 0 {
+				return p.failed(fmt.Sprintf("%d bytes, not a multiple of %d bytes per line", size, p.info.BytesPerLine))
+			}
+			lines = int(size / int64(p.info.BytesPerLine))
+		}
+	case "Jpeg":
+		r, err := p.buf.Reader()
+		if err != nil {
+			return err
+		}
+		eoi := new(eoiWriter)
+		frame, _, err := jpegfix.FixFrame(eoi, r, 0)
+		if err != nil {
+			return p.failed(err.Error())
+		}
+		if !eoi.ended() {
+			return p.failed("no EOI marker")
+		}
+		if frame.Lines != jpegfix.UnknownLines {
+			lines = frame.Lines
+		}
+	}
+
+	if lines == 0 {
+		return nil
+	}
+	if totalLines > 0 && lines != totalLines {
+		return p.failed(fmt.Sprintf("%d lines received, the device reports %d lines", lines, totalLines))
+	}
+	// The feeder can give a shorter page than announced
+	height := p.info.Height
+	if height > 0 && (lines > height || (lines < height && p.info.InputSource != SourceAdf)) {
+		return p.failed(fmt.Sprintf("%d lines received, the device announced %d lines", lines, height))
+	}
+	return nil
+}
+
+// eoiWriter tells if the stream written ends with an EOI marker, padding zeros aside
+type eoiWriter struct {
+	last [2]byte
+}
+
+func (w *eoiWriter) Write(b []byte) (int, error) {
+	for _, c := range b {
+		if c != 0 {
+			w.last[0], w.last[1] = w.last[1], c
+		}
+	}
+	return len(b), nil
+}
+
+func (w *eoiWriter) ended() bool {
+	return w.last == [2]byte{0xFF, 0xD9}
+}
+
+func (p *pendingPage) failed(reason string) error {
+	return HPDeviceError{"ScanJob.check", fmt.Sprintf("Page %d: %s", p.info.PageNumber, reason), ErrPageIncomplete}
+}
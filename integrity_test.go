@@ -0,0 +1,125 @@
+package hpdevices
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+func pending(t *testing.T, info PageInfo, data []byte) *pendingPage {
+	buf := &pageBuffer{limit: 1 << 20}
+	buf.Write(data)
+	t.Cleanup(func() { buf.Close() })
+	return &pendingPage{info: info, buf: buf}
+}
+
+func Test_PageCheck(t *testing.T) {
+	page := newFakeScanner(t, 1).pages[0]
+	jpegInfo := PageInfo{PageNumber: 1, Height: 40, Format: "Jpeg", InputSource: SourcePlaten}
+	rawInfo := PageInfo{PageNumber: 1, Height: 40, BytesPerLine: 30, Format: "Raw", InputSource: SourcePlaten}
+	adfInfo := rawInfo
+	adfInfo.InputSource = SourceAdf
+
+	for name, test := range map[string]struct {
+		info       PageInfo
+		data       []byte
+		totalLines int
+		ok         bool
+	}{
+		"jpeg":            {jpegInfo, page, 40, true},
+		"jpeg padded":     {jpegInfo, append(append([]byte{}, page...), 0, 0, 0), 40, true},
+		"jpeg truncated":  {jpegInfo, page[:len(page)-64], 0, false},
+		"jpeg total":      {jpegInfo, page, 38, false},
+		"jpeg empty":      {jpegInfo, nil, 0, false},
+		"raw":             {rawInfo, make([]byte, 30*40), 40, true},
+		"raw partial":     {rawInfo, make([]byte, 30*40-10), 0, false},
+		"raw short":       {rawInfo, make([]byte, 30*20), 0, false},
+		"raw total":       {rawInfo, make([]byte, 30*40), 41, false},
+		"raw adf short":   {adfInfo, make([]byte, 30*20), 20, true},
+		"raw adf too big": {adfInfo, make([]byte, 30*50), 0, false},
+	} {
+		err := pending(t, test.info, test.data).check(test.totalLines)
+		if (err == nil) != test.ok {
+			t.Errorf("%s: unexpected result %v", name, err)
+		}
+		if err != nil && !errors.Is(err, ErrPageIncomplete) {
+			t.Errorf("%s: unexpected error %v", name, err)
+		}
+	}
+}
+
+// failurePageWriter handles failing pages with the action
+type failurePageWriter struct {
+	*memoryPageWriter
+	action   PageAction
+	failures []PageFailure
+}
+
+func (f *failurePageWriter) PageFailed(failure PageFailure) PageAction {
+	f.failures = append(f.failures, failure)
+	return f.action
+}
+
+func Test_PageFailure(t *testing.T) {
+	fastPolling(t)
+
+	// Without handler, the job is aborted
+	f := newFakeScanner(t, 1)
+	f.truncate = 1
+	w := new(memoryPageWriter)
+	if err := f.device().Scan(context.Background(), NewScanOptions(), w); !errors.Is(err, ErrPageIncomplete) {
+		t.Fatalf("expected ErrPageIncomplete, got %v", err)
+	}
+	if len(w.pages) != 0 {
+		t.Error("incomplete page written")
+	}
+
+	// The page is downloaded again, the handler is found through the PNG writer
+	f = newFakeScanner(t, 1)
+	f.truncate = 1
+	retry := &failurePageWriter{memoryPageWriter: new(memoryPageWriter), action: RetryPage}
+	if err := f.device().Scan(context.Background(), NewScanOptions(), PNGWriter{retry}); err != nil {
+		t.Fatal(err)
+	}
+	if len(retry.failures) != 1 || retry.failures[0].Attempt != 1 || retry.failures[0].Info.PageNumber != 1 || len(retry.pages) != 1 {
+		t.Errorf("page not downloaded again: %+v", retry.failures)
+	}
+
+	// Retries are limited
+	f = newFakeScanner(t, 1)
+	f.truncate = 10
+	d := f.device()
+	d.PageDownload.Retries = 2
+	retry = &failurePageWriter{memoryPageWriter: new(memoryPageWriter), action: RetryPage}
+	if err := d.Scan(context.Background(), NewScanOptions(), retry); !errors.Is(err, ErrPageIncomplete) || len(retry.failures) != 3 {
+		t.Errorf("retries not limited: %d failures, %v", len(retry.failures), err)
+	}
+
+	// The failing page is skipped, the job goes on
+	f = newFakeScanner(t, 2)
+	f.truncate = 1
+	skip := &failurePageWriter{memoryPageWriter: new(memoryPageWriter), action: SkipPage}
+	if err := f.device().Scan(context.Background(), NewScanOptions(), skip); err != nil {
+		t.Fatal(err)
+	}
+	if len(skip.pages) != 1 || skip.infos[0].PageNumber != 2 || !bytes.Equal(skip.pages[0].Bytes(), f.pages[1]) || len(skip.results) != 1 {
+		t.Errorf("page not skipped: %d pages", len(skip.pages))
+	}
+}
+
+func Test_MissedPostScanPage(t *testing.T) {
+	fastPolling(t)
+	f := newFakeScanner(t, 2)
+	f.noPostScan = map[int]bool{1: true}
+	w := new(memoryPageWriter)
+	if err := f.device().Scan(context.Background(), NewScanOptions(), w); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.pages) != 2 || !bytes.Equal(w.pages[0].Bytes(), f.pages[0]) || !bytes.Equal(w.pages[1].Bytes(), f.pages[1]) {
+		t.Fatalf("expected 2 pages, got %d", len(w.pages))
+	}
+	if len(w.results) != 2 || w.results[0].PageNumber != 1 || w.results[0].TotalLines != 0 || w.results[1].TotalLines != f.height {
+		t.Errorf("unexpected results %+v", w.results)
+	}
+}
@@ -0,0 +1,285 @@
+// job.go
+package hpdevices
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+)
+
+// Job states, as reported by the device, plus Pending and Failed
+const (
+	JobPending    = "Pending" // Not yet posted to the device
+	JobProcessing = "Processing"
+	JobCompleted  = "Completed"
+	JobCanceled   = "Canceled"
+	JobFailed     = "Failed"
+)
+
+type ScanEventType int
+
+const (
+	JobStateChanged  ScanEventType = iota // JobState has changed
+	PreScanPage                           // A page is preparing, or ready to upload
+	DownloadProgress                      // Part of the page has been downloaded
+	PostScanPage                          // A page is completed, or canceled by the device
+	JobQueued                             // The job waits for other jobs of the device
+)
+
+func (t ScanEventType) String() string {
+	switch t {
+	case JobStateChanged:
+		return "JobStateChanged"
+	case PreScanPage:
+		return "PreScanPage"
+	case DownloadProgress:
+		return "DownloadProgress"
+	case PostScanPage:
+		return "PostScanPage"
+	case JobQueued:
+		return "JobQueued"
+	}
+	return "Unknown"
+}
+
+// ScanEvent reports the progress of a scan job. Fields are set according to the event type.
+type ScanEvent struct {
+	Type       ScanEventType
+	JobState   string
+	PageNumber int
+	PageState  string // PreparingScan, ReadyToUpload, UploadCompleted, CanceledByDevice
+
+	// PreScanPage and DownloadProgress
+	ImageWidth   int
+	ImageHeight  int
+	BytesPerLine int
+
+	// DownloadProgress
+	Bytes int64 // Bytes downloaded for the page
+	Lines int   // Lines downloaded, estimated for compressed formats
+
+	// PostScanPage
+	TotalLines int
+
+	// JobQueued
+	QueuePosition int // Jobs before this one, 0 when its turn has come
+}
+
+// progressEventStep limits the number of DownloadProgress events
+const progressEventStep = 64 * 1024
+
+// ScanJob is a scan job running in background
+type ScanJob struct {
+	sj     *hpscanJob
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+
+	mutex    sync.Mutex
+	state    string
+	position int         // Position in the device queue
+	queue    []ScanEvent // Events waiting to be delivered
+	wakeup   chan struct{}
+	events   chan ScanEvent
+	deliver  sync.Once     // Delivery starts with the first call to Events
+	stop     chan struct{} // Closed by StopEvents
+	stopOnce sync.Once
+}
+
+// StartScan launches a scan job and returns immediately. Settings are checked
+// before returning, other errors are given by Wait. Jobs of a device run one at
+// a time: the job is pending until the previous ones are over and the scanner is idle.
+func (d *HPDevice) StartScan(ctx context.Context, opts ScanOptions, writer PageWriter) (*ScanJob, error) {
+	ss, err := d.scanSettings(ctx, opts)
+	if err != nil {
+		return nil, NewHPDeviceError("HPDevice.StartScan", "Settings", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	job := &ScanJob{
+		sj:     d.newScanJob(writer, opts),
+		cancel: cancel,
+		done:   make(chan struct{}),
+		state:  JobPending,
+		wakeup: make(chan struct{}, 1),
+		events: make(chan ScanEvent),
+		stop:   make(chan struct{}),
+	}
+	job.sj.events = job.emit
+	go job.run(ctx, ss)
+	return job, nil
+}
+
+func (job *ScanJob) run(ctx context.Context, ss scanSettings) {
+	defer close(job.done)
+	defer job.cancel()
+	release, err := job.sj.acquire(ctx)
+	if err == nil {
+		err = job.sj.post(ctx, ss)
+		if err == nil {
+			job.sj.jobState = JobProcessing
+			job.emit(ScanEvent{Type: JobStateChanged, JobState: JobProcessing})
+			err = job.sj.poll(ctx)
+		}
+		release()
+	}
+
+	job.mutex.Lock()
+	job.err = err
+	state := job.state
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrScanCanceled):
+		state = JobCanceled
+	case state != JobCanceled:
+		state = JobFailed
+	}
+	if state != job.state {
+		job.state = state
+		job.queue = append(job.queue, ScanEvent{Type: JobStateChanged, JobState: state})
+	}
+	job.mutex.Unlock()
+	job.signal()
+}
+
+// emit queues the event, the job is never blocked by a slow reader
+func (job *ScanJob) emit(e ScanEvent) {
+	job.mutex.Lock()
+	switch e.Type {
+	case JobStateChanged:
+		job.state = e.JobState
+	case JobQueued:
+		job.position = e.QueuePosition
+	}
+	select {
+	case <-job.stop:
+		// Nobody reads events anymore
+	default:
+		job.queue = append(job.queue, e)
+	}
+	job.mutex.Unlock()
+	job.signal()
+}
+
+func (job *ScanJob) signal() {
+	select {
+	case job.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// deliverEvents sends queued events to the channel, and closes it once the job is done or StopEvents is called
+func (job *ScanJob) deliverEvents() {
+	defer close(job.events)
+	for {
+		job.mutex.Lock()
+		queue := job.queue
+		job.queue = nil
+		job.mutex.Unlock()
+
+		for _, e := range queue {
+			select {
+			case job.events <- e:
+			case <-job.stop:
+				return
+			}
+		}
+		if len(queue) > 0 {
+			continue
+		}
+		select {
+		case <-job.stop:
+			return
+		case <-job.wakeup:
+		case <-job.done:
+			job.mutex.Lock()
+			empty := len(job.queue) == 0
+			job.mutex.Unlock()
+			if empty {
+				return
+			}
+		}
+	}
+}
+
+// Events gives the channel of job events. It's closed when the job is over.
+// Events are kept until read, the job is never slowed down by the reader.
+// The channel must be read until closed, or StopEvents called when leaving before.
+func (job *ScanJob) Events() <-chan ScanEvent {
+	job.deliver.Do(func() { go job.deliverEvents() })
+	return job.events
+}
+
+// StopEvents is called by readers leaving before the end of the events. Events not
+// read yet are dropped, and the channel is closed. The job goes on.
+func (job *ScanJob) StopEvents() {
+	job.stopOnce.Do(func() { close(job.stop) })
+}
+
+// Wait waits the end of the job, and returns its error
+func (job *ScanJob) Wait() error {
+	<-job.done
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	return job.err
+}
+
+// Cancel stops the job, like the cancellation of its context. The job is canceled
+// on the device, and Wait returns ErrScanCanceled once the scanner is idle again.
+func (job *ScanJob) Cancel() {
+	job.cancel()
+}
+
+// State returns the current job state
+func (job *ScanJob) State() string {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	return job.state
+}
+
+// QueuePosition returns the number of jobs of the device to run before this one.
+// Jobs of a device run one at a time, a pending job can be canceled while queued.
+func (job *ScanJob) QueuePosition() int {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	return job.position
+}
+
+// progressReader sends DownloadProgress events while a page is read
+type progressReader struct {
+	r        io.Reader
+	page     *preScanPage
+	events   func(ScanEvent)
+	bytes    int64
+	reported int64
+}
+
+func (p *progressReader) Read(b []byte) (n int, err error) {
+	n, err = p.r.Read(b)
+	p.bytes += int64(n)
+	if p.bytes-p.reported >= progressEventStep || (err == io.EOF && p.bytes > p.reported) {
+		p.reported = p.bytes
+		info := p.page.BufferInfo
+		lines := 0
+		if info.BytesPerLine > 0 {
+			// Exact for raw images, an estimation for compressed ones until the end of the page
+			lines = int(p.bytes / int64(info.BytesPerLine))
+			if lines > info.ImageHeight || err == io.EOF {
+				lines = info.ImageHeight
+			}
+		}
+		p.events(ScanEvent{
+			Type:         DownloadProgress,
+			JobState:     JobProcessing,
+			PageNumber:   p.page.PageNumber,
+			PageState:    p.page.PageState,
+			ImageWidth:   info.ImageWidth,
+			ImageHeight:  info.ImageHeight,
+			BytesPerLine: info.BytesPerLine,
+			Bytes:        p.bytes,
+			Lines:        lines,
+		})
+	}
+	return n, err
+}
@@ -0,0 +1,506 @@
+package hpdevices
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeScanner simulates the job state machine of a device: each page is
+// preparing, then ready to upload, then completed once downloaded.
+type fakeScanner struct {
+	mutex      sync.Mutex
+	pages      [][]byte
+	height     int
+	page       int  // Index of the current page
+	ready      bool // Current page is ready to upload
+	downloaded bool // Current page has been downloaded
+	posted     int
+	settings   scanSettings // Last settings posted
+	jobStatus  int          // Status code of the job POST, 201 when 0
+	canceled   bool
+	busy       int           // Status queries answered busy after a cancellation
+	state      string        // Scanner state when not busy, Idle when empty
+	stall      chan struct{} // When set, downloads stop before the end of the page until closed
+	stalled    chan struct{} // Closed when a download is stopped by stall
+	drops      int           // Downloads cut in the middle of the page
+	ranges     bool          // Range requests are honoured
+	truncate   int           // Downloads ending early without error
+	requests   []string      // Range header of each page request
+	noPostScan map[int]bool  // Pages whose PostScanPage is missed, the next page being ready at once
+
+	orientations map[int]string // ImageOrientation of pages, Normal by default
+
+	prepare  time.Duration // When set, pages are ready after this time instead of at the second query
+	events   bool          // Serves an event table with long-poll
+	revision int           // Version of the event table
+	changed  chan struct{} // Closed when the event table changes
+}
+
+func newFakeScanner(t testing.TB, pages int) *fakeScanner {
+	f := &fakeScanner{height: 40}
+	for i := 0; i < pages; i++ {
+		img := image.NewGray(image.Rect(0, 0, 30, f.height))
+		for j := range img.Pix {
+			img.Pix[j] = byte(i * 50)
+		}
+		var b bytes.Buffer
+		if err := jpeg.Encode(&b, img, nil); err != nil {
+			t.Fatal(err)
+		}
+		f.pages = append(f.pages, b.Bytes())
+	}
+	return f
+}
+
+// device gives a device talking to the fake scanner without network
+func (f *fakeScanner) device() *HPDevice {
+	return &HPDevice{
+		URL:       "http://scanner",
+		Client:    &http.Client{Transport: handlerTransport{f}},
+		resources: &resourceCache{resources: &DeviceResources{}},
+	}
+}
+
+func (f *fakeScanner) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/Scan/Jobs/1/Pages/") {
+		f.servePage(w, r)
+		return
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	switch {
+	case r.Method == "POST" && r.URL.Path == "/Scan/Jobs":
+		f.posted++
+		buffer, _ := ioutil.ReadAll(r.Body)
+		xml.Unmarshal(buffer, &f.settings)
+		if f.jobStatus != 0 {
+			w.WriteHeader(f.jobStatus)
+			return
+		}
+		w.Header().Set("Location", "/Jobs/JobList/1")
+		w.WriteHeader(201)
+		f.startPage()
+	case r.Method == "PUT" && r.URL.Path == "/Jobs/JobList/1":
+		update := new(jobStateUpdate)
+		buffer, _ := ioutil.ReadAll(r.Body)
+		if err := xml.Unmarshal(buffer, update); err != nil || update.JobState != "Canceled" {
+			http.Error(w, "bad job update", 400)
+			return
+		}
+		f.canceled = true
+		f.busy = 2
+		f.notify()
+	case r.URL.Path == "/Jobs/JobList/1":
+		fmt.Fprint(w, f.jobXML())
+	case r.URL.Path == "/EventMgmt/EventTable" && f.events:
+		f.serveEvents(w, r)
+	case r.URL.Path == "/Scan/ScanCaps":
+		fmt.Fprint(w, scanCapsXML)
+	case r.URL.Path == "/Scan/Status":
+		state := "Idle"
+		if f.state != "" {
+			state = f.state
+		}
+		if f.busy > 0 {
+			f.busy--
+			state = "BusyWithScanJob"
+		}
+		fmt.Fprint(w, `<ScanStatus xmlns="http://www.hp.com/schemas/imaging/con/cnx/scan/2008/08/19"><ScannerState>`+state+`</ScannerState><AdfState>Empty</AdfState></ScanStatus>`)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// servePage sends the current page, without holding the lock while stalled
+func (f *fakeScanner) servePage(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	n, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/Scan/Jobs/1/Pages/"))
+	if n != f.page+1 || !f.ready || f.canceled {
+		f.mutex.Unlock()
+		http.NotFound(w, r)
+		return
+	}
+	page, stall := f.pages[f.page], f.stall
+	f.requests = append(f.requests, r.Header.Get("Range"))
+	drop := f.drops > 0
+	if drop {
+		f.drops--
+	}
+	if f.truncate > 0 {
+		f.truncate--
+		page = page[:len(page)-64]
+	}
+	if offset := strings.TrimSuffix(strings.TrimPrefix(r.Header.Get("Range"), "bytes="), "-"); f.ranges && offset != "" {
+		start, _ := strconv.Atoi(offset)
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(page)-1, len(page)))
+		w.Header().Set("Content-Length", strconv.Itoa(len(page)-start))
+		w.WriteHeader(206)
+		page = page[start:]
+	} else {
+		w.Header().Set("Content-Length", strconv.Itoa(len(page)))
+	}
+	f.mutex.Unlock()
+
+	if drop {
+		// The connection is lost in the middle of the page
+		w.Write(page[:len(page)/2])
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	if stall != nil {
+		w.Write(page[:len(page)-64])
+		w.(http.Flusher).Flush()
+		if f.stalled != nil {
+			close(f.stalled)
+		}
+		select {
+		case <-stall:
+		case <-r.Context().Done():
+			return
+		}
+		page = page[len(page)-64:]
+	}
+	w.Write(page)
+	f.mutex.Lock()
+	f.downloaded = true
+	f.notify()
+	f.mutex.Unlock()
+}
+
+// jobXML gives the job state, and moves the state machine one step further
+func (f *fakeScanner) jobXML() string {
+	state, pages := "Processing", ""
+	switch {
+	case f.canceled:
+		state = "Canceled"
+	case f.page >= len(f.pages):
+		state = "Completed"
+		pages = f.postScanXML(len(f.pages))
+	case f.downloaded && f.noPostScan[f.page+1] && f.page+1 < len(f.pages):
+		f.page++
+		f.downloaded = false
+		pages = f.preScanXML("ReadyToUpload")
+	case f.downloaded:
+		pages = f.postScanXML(f.page + 1)
+		f.page++
+		f.ready, f.downloaded = false, false
+		f.startPage()
+		if f.page >= len(f.pages) {
+			f.notify() // The job is completed
+		}
+	case f.ready:
+		pages = f.preScanXML("ReadyToUpload")
+	default:
+		pages = f.preScanXML("PreparingScan")
+		f.ready = f.prepare == 0
+	}
+	return `<j:Job xmlns:j="http://www.hp.com/schemas/imaging/con/ledm/jobs/2009/04/30" xmlns:scan="http://www.hp.com/schemas/imaging/con/cnx/scan/2008/08/19">` +
+		`<j:JobUrl>/Jobs/JobList/1</j:JobUrl><j:JobCategory>Scan</j:JobCategory><j:JobState>` + state + `</j:JobState>` +
+		`<scan:ScanJob>` + pages + `</scan:ScanJob></j:Job>`
+}
+
+// startPage makes the current page ready after the preparation time
+func (f *fakeScanner) startPage() {
+	if f.prepare == 0 || f.page >= len(f.pages) {
+		return
+	}
+	page := f.page
+	time.AfterFunc(f.prepare, func() {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		if f.page == page && !f.canceled {
+			f.ready = true
+			f.notify()
+		}
+	})
+}
+
+// notify adds an event to the table
+func (f *fakeScanner) notify() {
+	f.revision++
+	if f.changed != nil {
+		close(f.changed)
+		f.changed = nil
+	}
+}
+
+// serveEvents answers with the event table, waiting for a change when the
+// client knows the current version and gives a timeout in 1/10 of second
+func (f *fakeScanner) serveEvents(w http.ResponseWriter, r *http.Request) {
+	etag := strconv.Itoa(f.revision)
+	tenths, _ := strconv.Atoi(r.URL.Query().Get("timeout"))
+	if r.Header.Get("If-None-Match") == etag && tenths > 0 {
+		if f.changed == nil {
+			f.changed = make(chan struct{})
+		}
+		changed := f.changed
+		f.mutex.Unlock()
+		select {
+		case <-changed:
+		case <-time.After(time.Duration(tenths) * 100 * time.Millisecond):
+		case <-r.Context().Done():
+		}
+		f.mutex.Lock()
+		etag = strconv.Itoa(f.revision)
+	}
+	w.Header().Set("Etag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(304)
+		return
+	}
+	fmt.Fprint(w, `<ev:EventTable xmlns:ev="http://www.hp.com/schemas/imaging/con/ledm/events/2007/09/16" xmlns:dd="http://www.hp.com/schemas/imaging/con/dictionaries/1.0/">`+
+		`<ev:Event><dd:UnqualifiedEventCategory>JobEvent</dd:UnqualifiedEventCategory><ev:Payload><dd:ResourceURI>/Jobs/JobList/1</dd:ResourceURI></ev:Payload></ev:Event></ev:EventTable>`)
+}
+
+func (f *fakeScanner) preScanXML(state string) string {
+	n := strconv.Itoa(f.page + 1)
+	orientation := f.orientations[f.page+1]
+	if orientation == "" {
+		orientation = "Normal"
+	}
+	return `<scan:PreScanPage><scan:PageNumber>` + n + `</scan:PageNumber><scan:PageState>` + state + `</scan:PageState>` +
+		`<scan:BufferInfo><scan:ImageWidth>30</scan:ImageWidth><scan:ImageHeight>` + strconv.Itoa(f.height) + `</scan:ImageHeight>` +
+		`<scan:BytesPerLine>30</scan:BytesPerLine></scan:BufferInfo>` +
+		`<scan:BinaryURL>/Scan/Jobs/1/Pages/` + n + `</scan:BinaryURL><scan:ImageOrientation>` + orientation + `</scan:ImageOrientation></scan:PreScanPage>`
+}
+
+func (f *fakeScanner) postScanXML(page int) string {
+	return `<scan:PostScanPage><scan:PageNumber>` + strconv.Itoa(page) + `</scan:PageNumber>` +
+		`<scan:PageState>UploadCompleted</scan:PageState><scan:TotalLines>` + strconv.Itoa(f.height) + `</scan:TotalLines></scan:PostScanPage>`
+}
+
+// memoryPageWriter keeps pages in memory, with their information and results
+type memoryPageWriter struct {
+	mutex   sync.Mutex
+	pages   []*bytes.Buffer
+	infos   []PageInfo
+	results []PageResult
+}
+
+func (m *memoryPageWriter) NewPage(info PageInfo) (io.WriteCloser, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	b := new(bytes.Buffer)
+	m.pages = append(m.pages, b)
+	m.infos = append(m.infos, info)
+	return nopWriteCloser{b}, nil
+}
+
+func (m *memoryPageWriter) EndPage(result PageResult) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.results = append(m.results, result)
+	return nil
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// fastPolling speeds up the job loop during a test
+func fastPolling(t testing.TB) {
+	polling, idle, download := DefaultJobPolling, idlePollInterval, DefaultPageDownload
+	DefaultJobPolling, idlePollInterval = JobPolling{Min: time.Millisecond, Max: time.Millisecond}, time.Millisecond
+	DefaultPageDownload.RetryDelay = time.Millisecond
+	t.Cleanup(func() { DefaultJobPolling, idlePollInterval, DefaultPageDownload = polling, idle, download })
+}
+
+func Test_StartScan(t *testing.T) {
+	fastPolling(t)
+	f := newFakeScanner(t, 2)
+	w := new(memoryPageWriter)
+	job, err := f.device().StartScan(context.Background(), NewScanOptions(), w)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var steps []string
+	progress := 0
+	for e := range job.Events() {
+		switch e.Type {
+		case DownloadProgress:
+			progress++
+			if e.Lines != f.height || e.Bytes != int64(len(f.pages[e.PageNumber-1])) {
+				t.Errorf("unexpected progress %+v", e)
+			}
+		case JobStateChanged:
+			steps = append(steps, e.JobState)
+		default:
+			steps = append(steps, e.Type.String()+" "+strconv.Itoa(e.PageNumber)+" "+e.PageState)
+		}
+	}
+	if err = job.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"Processing",
+		"PreScanPage 1 PreparingScan",
+		"PreScanPage 1 ReadyToUpload",
+		"PostScanPage 1 UploadCompleted",
+		"PreScanPage 2 PreparingScan",
+		"PreScanPage 2 ReadyToUpload",
+		"PostScanPage 2 UploadCompleted",
+		"Completed",
+	}
+	if strings.Join(steps, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected events:\n%s", strings.Join(steps, "\n"))
+	}
+	if progress != 2 {
+		t.Errorf("expected one progress event per page, got %d", progress)
+	}
+	if job.State() != JobCompleted {
+		t.Errorf("expected Completed, got %s", job.State())
+	}
+	if len(w.pages) != 2 || !bytes.Equal(w.pages[1].Bytes(), f.pages[1]) {
+		t.Errorf("pages not written as downloaded")
+	}
+}
+
+func Test_StartScanFailed(t *testing.T) {
+	fastPolling(t)
+	f := newFakeScanner(t, 1)
+	f.jobStatus = 503
+	job, err := f.device().StartScan(context.Background(), NewScanOptions(), new(memoryPageWriter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Events aren't read, the job must not be blocked
+	if err = job.Wait(); err == nil {
+		t.Fatal("expected an error")
+	}
+	if job.State() != JobFailed {
+		t.Errorf("expected Failed, got %s", job.State())
+	}
+	var last ScanEvent
+	for e := range job.Events() {
+		last = e
+	}
+	if last.Type != JobStateChanged || last.JobState != JobFailed {
+		t.Errorf("unexpected last event %+v", last)
+	}
+}
+
+func Test_StopEvents(t *testing.T) {
+	fastPolling(t)
+	f := newFakeScanner(t, 2)
+	job, err := f.device().StartScan(context.Background(), NewScanOptions(), new(memoryPageWriter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The reader leaves at the first event
+	events := job.Events()
+	<-events
+	job.StopEvents()
+	if err = job.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("events channel not closed")
+		}
+	}
+}
+
+// pipePageWriter records the error the page is closed with, like a io.PipeWriter
+type pipePageWriter struct {
+	written  chan struct{} // Closed at the first write
+	once     sync.Once
+	closeErr chan error
+}
+
+func newPipePageWriter() *pipePageWriter {
+	return &pipePageWriter{written: make(chan struct{}), closeErr: make(chan error, 10)}
+}
+
+func (p *pipePageWriter) NewPage(info PageInfo) (io.WriteCloser, error) { return p, nil }
+func (p *pipePageWriter) EndPage(result PageResult) error               { return nil }
+
+func (p *pipePageWriter) Write(b []byte) (int, error) {
+	p.once.Do(func() { close(p.written) })
+	return len(b), nil
+}
+
+func (p *pipePageWriter) Close() error { return p.CloseWithError(nil) }
+
+func (p *pipePageWriter) CloseWithError(err error) error {
+	p.closeErr <- err
+	return nil
+}
+
+func Test_CancelScan(t *testing.T) {
+	fastPolling(t)
+	f := newFakeScanner(t, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	job, err := f.device().StartScan(ctx, NewScanOptions(), new(memoryPageWriter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for e := range job.Events() {
+		if e.Type == PreScanPage {
+			cancel()
+		}
+	}
+	if err = job.Wait(); !errors.Is(err, ErrScanCanceled) {
+		t.Fatalf("expected ErrScanCanceled, got %v", err)
+	}
+	if job.State() != JobCanceled || !f.canceled || f.busy != 0 {
+		t.Errorf("job not canceled on device: state %s, canceled %v, busy %d", job.State(), f.canceled, f.busy)
+	}
+}
+
+func Test_CancelDownload(t *testing.T) {
+	fastPolling(t)
+	f := newFakeScanner(t, 1)
+	f.stall = make(chan struct{})
+	defer close(f.stall)
+	server := httptest.NewServer(f)
+	defer server.Close()
+
+	f.stalled = make(chan struct{})
+	w := newPipePageWriter()
+	d := &HPDevice{URL: server.URL, resources: &resourceCache{resources: &DeviceResources{}}}
+	job, err := d.StartScan(context.Background(), NewScanOptions(), w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-f.stalled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("download not started")
+	}
+	job.Cancel()
+	if err = job.Wait(); !errors.Is(err, ErrScanCanceled) {
+		t.Fatalf("expected ErrScanCanceled, got %v", err)
+	}
+	select {
+	case <-w.written:
+		t.Error("partial page given to the writer")
+	default:
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if !f.canceled || f.downloaded {
+		t.Errorf("download not aborted: canceled %v, downloaded %v", f.canceled, f.downloaded)
+	}
+}
@@ -0,0 +1,184 @@
+// Package jpegfix repairs the JPEG streams delivered by HP scanners.
+//
+// When scanning with the ADF, the device doesn't know the page length when it
+// starts sending the image. The frame header then has 0xFFFF lines, which most
+// decoders refuse. Fix copies the stream, with the real line count in the header.
+package jpegfix
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	ErrNotJPEG = errors.New("jpegfix: not a JPEG stream")
+	ErrNoFrame = errors.New("jpegfix: no frame header before the image data")
+)
+
+// UnknownLines is the line count given by the device when the page length isn't known
+const UnknownLines = 0xFFFF
+
+// JPEG markers
+const (
+	markerSOI   = 0xD8
+	markerEOI   = 0xD9
+	markerSOS   = 0xDA
+	markerTEM   = 0x01
+	markerRST0  = 0xD0
+	markerRST7  = 0xD7
+	markerSOF0  = 0xC0 // Baseline
+	markerSOF1  = 0xC1 // Extended sequential
+	markerSOF2  = 0xC2 // Progressive
+	markerDHT   = 0xC4
+	markerJPG   = 0xC8
+	markerDAC   = 0xCC
+	markerSOF15 = 0xCF // Last frame marker
+)
+
+// Frame is the frame header found in the stream
+type Frame struct {
+	Marker     byte // 0xC0 for SOF0, 0xC1 for SOF1, 0xC2 for SOF2...
+	Precision  int
+	Lines      int // As given by the device
+	Width      int
+	Components int
+	Patched    bool // Lines has been replaced
+}
+
+// Fix copies the JPEG stream r to w. When the frame header is a SOF0, SOF1 or SOF2
+// having UnknownLines, lines is written instead. Segments before the frame can
+// have any size, and markers can be preceded by fill bytes, which are kept.
+func Fix(w io.Writer, r io.Reader, lines int) (written int64, err error) {
+	_, written, err = FixFrame(w, r, lines)
+	return written, err
+}
+
+// FixFrame is Fix, giving also the frame header
+func FixFrame(w io.Writer, r io.Reader, lines int) (frame Frame, written int64, err error) {
+	br := bufio.NewReader(r)
+	cw := &countingWriter{w: w}
+
+	soi := make([]byte, 2)
+	if _, err = io.ReadFull(br, soi); err != nil || soi[0] != 0xFF || soi[1] != markerSOI {
+		return frame, 0, ErrNotJPEG
+	}
+	if _, err = cw.Write(soi); err != nil {
+		return frame, cw.n, err
+	}
+
+	for {
+		marker, err := copyMarker(cw, br)
+		if err != nil {
+			return frame, cw.n, err
+		}
+		switch {
+		case marker == markerSOS || marker == markerEOI:
+			return frame, cw.n, ErrNoFrame
+		case marker == markerTEM || (marker >= markerRST0 && marker <= markerRST7):
+			continue // No segment
+		}
+
+		segment, err := readSegment(br)
+		if err != nil {
+			return frame, cw.n, err
+		}
+		isFrame := marker >= markerSOF0 && marker <= markerSOF15 && marker != markerDHT && marker != markerJPG && marker != markerDAC
+		if isFrame {
+			frame, err = parseFrame(marker, segment)
+			if err != nil {
+				return frame, cw.n, err
+			}
+			if frame.Lines == UnknownLines && lines > 0 && lines < UnknownLines && marker <= markerSOF2 {
+				segment[3], segment[4] = byte(lines>>8), byte(lines)
+				frame.Patched = true
+			}
+		}
+		if _, err = cw.Write(segment); err != nil {
+			return frame, cw.n, err
+		}
+		if isFrame {
+			// The rest of the stream is copied as is
+			_, err = io.Copy(cw, br)
+			return frame, cw.n, err
+		}
+	}
+}
+
+// copyMarker reads the next marker, and copies it with its fill bytes
+func copyMarker(w io.Writer, r *bufio.Reader) (byte, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, unexpected(err)
+	}
+	if b != 0xFF {
+		return 0, fmt.Errorf("jpegfix: marker expected, got 0x%02X", b)
+	}
+	fill := []byte{0xFF}
+	for {
+		b, err = r.ReadByte()
+		if err != nil {
+			return 0, unexpected(err)
+		}
+		fill = append(fill, b)
+		if b != 0xFF {
+			break
+		}
+	}
+	if b == 0 {
+		return 0, errors.New("jpegfix: stuffed byte outside of image data")
+	}
+	_, err = w.Write(fill)
+	return b, err
+}
+
+// readSegment reads a segment with its length field
+func readSegment(r *bufio.Reader) ([]byte, error) {
+	length := make([]byte, 2)
+	if _, err := io.ReadFull(r, length); err != nil {
+		return nil, unexpected(err)
+	}
+	n := int(length[0])<<8 | int(length[1])
+	if n < 2 {
+		return nil, fmt.Errorf("jpegfix: invalid segment length %d", n)
+	}
+	segment := make([]byte, n)
+	copy(segment, length)
+	if _, err := io.ReadFull(r, segment[2:]); err != nil {
+		return nil, unexpected(err)
+	}
+	return segment, nil
+}
+
+// parseFrame reads a frame segment: length, precision, lines, width, components
+func parseFrame(marker byte, segment []byte) (Frame, error) {
+	if len(segment) < 8 {
+		return Frame{}, fmt.Errorf("jpegfix: frame header too short, %d bytes", len(segment))
+	}
+	return Frame{
+		Marker:     marker,
+		Precision:  int(segment[2]),
+		Lines:      int(segment[3])<<8 | int(segment[4]),
+		Width:      int(segment[5])<<8 | int(segment[6]),
+		Components: int(segment[7]),
+	}, nil
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,159 @@
+package jpegfix
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"io"
+	"io/ioutil"
+	"testing"
+	"testing/iotest"
+)
+
+// adfJPEG gives a JPEG image having 0xFFFF lines in its header, like the HP feeders
+func adfJPEG(t *testing.T, width, height int) []byte {
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, image.NewGray(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatal(err)
+	}
+	data := b.Bytes()
+	i := sofOffset(t, data)
+	data[i+5], data[i+6] = 0xFF, 0xFF
+	return data
+}
+
+// sofOffset finds the frame marker, the encoder doesn't put fill bytes
+func sofOffset(t *testing.T, data []byte) int {
+	for i := 2; i+4 < len(data); {
+		if data[i] == 0xFF && data[i+1] >= 0xC0 && data[i+1] <= 0xC2 {
+			return i
+		}
+		i += 2 + int(data[i+2])<<8 + int(data[i+3])
+	}
+	t.Fatal("no frame header")
+	return 0
+}
+
+// withSegment inserts an APP1 segment of the size after SOI
+func withSegment(data []byte, size int) []byte {
+	segment := make([]byte, 4+size)
+	segment[0], segment[1] = 0xFF, 0xE1
+	segment[2], segment[3] = byte((size+2)>>8), byte(size+2)
+	out := append([]byte{}, data[:2]...)
+	out = append(out, segment...)
+	return append(out, data[2:]...)
+}
+
+func decodeHeight(t *testing.T, data []byte) int {
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img.Bounds().Dy()
+}
+
+func Test_Fix(t *testing.T) {
+	data := adfJPEG(t, 16, 24)
+	var out bytes.Buffer
+	frame, written, err := FixFrame(&out, bytes.NewReader(data), 24)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written != int64(len(data)) || out.Len() != len(data) {
+		t.Errorf("written %d bytes for %d", written, len(data))
+	}
+	if !frame.Patched || frame.Lines != UnknownLines || frame.Width != 16 || frame.Marker != 0xC0 {
+		t.Errorf("unexpected frame %+v", frame)
+	}
+	if h := decodeHeight(t, out.Bytes()); h != 24 {
+		t.Errorf("height %d after fix", h)
+	}
+}
+
+func Test_FixLargeSegments(t *testing.T) {
+	// EXIF or ICC data pushing the frame header far from the start, read byte by byte
+	data := withSegment(withSegment(adfJPEG(t, 16, 24), 60000), 3000)
+	var out bytes.Buffer
+	_, err := Fix(&out, iotest.OneByteReader(bytes.NewReader(data)), 24)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := decodeHeight(t, out.Bytes()); h != 24 {
+		t.Errorf("height %d after fix", h)
+	}
+}
+
+func Test_FixFillBytes(t *testing.T) {
+	data := adfJPEG(t, 16, 24)
+	i := sofOffset(t, data)
+	filled := append(append(append([]byte{}, data[:i]...), 0xFF, 0xFF, 0xFF), data[i:]...)
+	var out bytes.Buffer
+	if _, err := Fix(&out, bytes.NewReader(filled), 24); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes()[:i+3], filled[:i+3]) {
+		t.Error("fill bytes not kept")
+	}
+	if h := decodeHeight(t, out.Bytes()); h != 24 {
+		t.Errorf("height %d after fix", h)
+	}
+}
+
+func Test_FixFrameMarkers(t *testing.T) {
+	for _, marker := range []byte{0xC1, 0xC2} {
+		data := adfJPEG(t, 16, 24)
+		i := sofOffset(t, data)
+		data[i+1] = marker
+		var out bytes.Buffer
+		frame, _, err := FixFrame(&out, bytes.NewReader(data), 24)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := out.Bytes(); frame.Marker != marker || got[i+5] != 0 || got[i+6] != 24 {
+			t.Errorf("SOF 0x%02X not patched: %+v", marker, frame)
+		}
+	}
+
+	// Lossless frames are kept
+	data := adfJPEG(t, 16, 24)
+	data[sofOffset(t, data)+1] = 0xC3
+	var out bytes.Buffer
+	if frame, _, err := FixFrame(&out, bytes.NewReader(data), 24); err != nil || frame.Patched || !bytes.Equal(out.Bytes(), data) {
+		t.Errorf("SOF3 modified: %+v %v", frame, err)
+	}
+}
+
+func Test_FixKeepsKnownLines(t *testing.T) {
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, image.NewGray(image.Rect(0, 0, 16, 24)), nil); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	frame, _, err := FixFrame(&out, bytes.NewReader(b.Bytes()), 100)
+	if err != nil || frame.Patched || !bytes.Equal(out.Bytes(), b.Bytes()) {
+		t.Errorf("header with known lines modified: %+v %v", frame, err)
+	}
+}
+
+func Test_FixErrors(t *testing.T) {
+	data := adfJPEG(t, 16, 24)
+	i := sofOffset(t, data)
+	for name, test := range map[string]struct {
+		data []byte
+		err  error
+	}{
+		"empty":     {nil, ErrNotJPEG},
+		"png":       {[]byte("\x89PNG\r\n\x1a\n"), ErrNotJPEG},
+		"truncated": {data[:i+4], io.ErrUnexpectedEOF},
+		"no frame":  {[]byte{0xFF, 0xD8, 0xFF, 0xDA, 0, 2}, ErrNoFrame},
+		"eoi":       {[]byte{0xFF, 0xD8, 0xFF, 0xD9}, ErrNoFrame},
+	} {
+		_, err := Fix(ioutil.Discard, bytes.NewReader(test.data), 24)
+		if err != test.err {
+			t.Errorf("%s: got %v, expected %v", name, err, test.err)
+		}
+	}
+	if _, err := Fix(ioutil.Discard, bytes.NewReader([]byte{0xFF, 0xD8, 0x12}), 24); err == nil {
+		t.Error("garbage accepted as a marker")
+	}
+}
@@ -0,0 +1,81 @@
+package hpdevices
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const productConfigDynXML = `<?xml version="1.0" encoding="UTF-8"?>
+<prdcfgdyn:ProductConfigDyn xmlns:prdcfgdyn="http://www.hp.com/schemas/imaging/con/ledm/productconfigdyn/2007/11/05" xmlns:dd="http://www.hp.com/schemas/imaging/con/dictionaries/1.0/">
+	<prdcfgdyn:ProductInformation>
+		<dd:MakeAndModel>HP Officejet Pro 8600</dd:MakeAndModel>
+		<dd:SerialNumber>CN1234ABCD</dd:SerialNumber>
+		<dd:UUID>1C852A4D-B800-1F08-ABCD-A0B3CC8F3E21</dd:UUID>
+	</prdcfgdyn:ProductInformation>
+</prdcfgdyn:ProductConfigDyn>`
+
+func Test_Identify(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/DevMgmt/ProductConfigDyn.xml" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, productConfigDynXML)
+	}))
+	defer server.Close()
+
+	d := &HPDevice{URL: server.URL}
+	if err := d.Identify(); err != nil {
+		t.Fatal(err)
+	}
+	if d.ModelName != "HP Officejet Pro 8600" || d.SerialNumber != "CN1234ABCD" || d.UUID != "1c852a4d-b800-1f08-abcd-a0b3cc8f3e21" {
+		t.Errorf("unexpected identity %+v", d)
+	}
+}
+
+func Test_SelectDevice(t *testing.T) {
+	devices := []*HPDevice{
+		{IPAddress: "10.0.0.10", HostName: "hp-hall.example.com", ModelName: "HP LaserJet MFP M426", SerialNumber: "PHB1"},
+		{IPAddress: "10.0.0.11", HostName: "hp-office.example.com", ModelName: "HP Officejet Pro 8600", SerialNumber: "CN2"},
+	}
+	tests := []struct {
+		match func(*HPDevice) bool
+		want  string
+	}{
+		{ByName("hp-office.example.com"), "10.0.0.11"},
+		{ByName("HP-OFFICE"), "10.0.0.11"},
+		{BySerial("phb1"), "10.0.0.10"},
+		{ByModel("officejet"), "10.0.0.11"},
+		{ByModel("HP"), "10.0.0.10"},
+	}
+	for _, test := range tests {
+		d, err := selectDevice("test", devices, test.match)
+		if err != nil || d.IPAddress != test.want {
+			t.Errorf("selectDevice got %v, %v, want %s", d, err, test.want)
+		}
+	}
+	for _, match := range []func(*HPDevice) bool{ByName("hp"), BySerial("PHB"), ByModel("Deskjet"), ByUUID("")} {
+		if _, err := selectDevice("test", devices, match); err == nil {
+			t.Error("selectDevice should fail when nothing matches")
+		}
+	}
+	if shortHostName("hp-office.example.com") != "hp-office" {
+		t.Error("shortHostName")
+	}
+}
+
+func Test_SLPMessages(t *testing.T) {
+	msg := slpServiceRequest(0x1234, slpHPServiceType)
+	if msg[0] != slpVersion || msg[1] != slpSrvRqst || int(msg[2])<<16|int(msg[3])<<8|int(msg[4]) != len(msg) {
+		t.Fatalf("bad SrvRqst header % x", msg[:14])
+	}
+	reply := []byte{slpVersion, slpSrvRply, 0, 0, 18, 0, 0, 0, 0, 0, 0x12, 0x34, 0, 2, 'e', 'n', 0, 0}
+	if !isSLPReply(reply, 0x1234) {
+		t.Error("reply not recognized")
+	}
+	if isSLPReply(reply, 0x4321) {
+		t.Error("reply to another request accepted")
+	}
+}
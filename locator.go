@@ -1,15 +1,184 @@
 package hpdevices
 
 import (
-	"github.com/simulot/srvloc"
+	"context"
+	"strings"
+	"sync"
+	"time"
 )
 
+// DiscoveryWindow is the time given to devices to answer a discovery probe
+var DiscoveryWindow = 3 * time.Second
+
+// DiscoverDevices probes the network during the discovery window, using the
+// default discoverer, and returns every responding device with its identity.
+func DiscoverDevices() (devices []*HPDevice, err error) {
+	return discoverWith(DefaultDiscoverer, DiscoveryWindow)
+}
+
+func discoverWith(discoverer Discoverer, window time.Duration) (devices []*HPDevice, err error) {
+	devices, err = discoverer.Probe(window)
+	if err != nil {
+		return nil, err
+	}
+
+	var wg sync.WaitGroup
+	for _, d := range devices {
+		wg.Add(1)
+		go func(d *HPDevice) {
+			defer wg.Done()
+			if d.URL == "" {
+				if err := d.NegotiateEndpoint(); err != nil {
+					d.URL = defaultURL(d.IPAddress)
+				}
+			}
+			if d.UUID != "" && d.SerialNumber != "" {
+				return
+			}
+			if err := d.Identify(); err != nil {
+				TRACE.Println("DiscoverDevices: can't identify device", d, err)
+			}
+		}(d)
+	}
+	wg.Wait()
+	// Devices answering on several addresses are known only now
+	devices = mergeDevices(nil, devices...)
+	if DefaultRegistry != nil {
+		if err := DefaultRegistry.Update(devices...); err != nil {
+			TRACE.Println("DiscoverDevices: registry not updated", err)
+		}
+	}
+	return devices, nil
+}
+
+// LocalizeDevice returns the first responding device
 func LocalizeDevice() (d *HPDevice, err error) {
-	//TODO: Use name to locate the correct device. At the moment, return the 1st responding device.
-	device, err := srvloc.ProbeHPPrinter()
-	if err == nil {
-		d = &HPDevice{URL: "http://" + device.IPAddress + ":8080"}
-		return d, err
+	return localize("LocalizeDevice", func(*HPDevice) bool { return true })
+}
+
+// LocalizeDeviceByName returns the device having the given host name
+func LocalizeDeviceByName(name string) (d *HPDevice, err error) {
+	return localize("LocalizeDeviceByName", ByName(name))
+}
+
+// LocalizeDeviceBySerial returns the device having the given serial number
+func LocalizeDeviceBySerial(serial string) (d *HPDevice, err error) {
+	return localize("LocalizeDeviceBySerial", BySerial(serial))
+}
+
+// LocalizeDeviceByModel returns the first device whose model name contains model
+func LocalizeDeviceByModel(model string) (d *HPDevice, err error) {
+	return localize("LocalizeDeviceByModel", ByModel(model))
+}
+
+// LocalizeDeviceByUUID returns the device having the given UUID
+func LocalizeDeviceByUUID(uuid string) (d *HPDevice, err error) {
+	return localize("LocalizeDeviceByUUID", ByUUID(uuid))
+}
+
+// ByName matches the device having the given host name, with or without its domain
+func ByName(name string) func(*HPDevice) bool {
+	return func(d *HPDevice) bool {
+		return d.HostName != "" && (strings.EqualFold(d.HostName, name) || strings.EqualFold(shortHostName(d.HostName), name))
+	}
+}
+
+// BySerial matches the device having the given serial number
+func BySerial(serial string) func(*HPDevice) bool {
+	return func(d *HPDevice) bool {
+		return d.SerialNumber != "" && strings.EqualFold(d.SerialNumber, serial)
+	}
+}
+
+// ByModel matches the devices whose model name contains model
+func ByModel(model string) func(*HPDevice) bool {
+	return func(d *HPDevice) bool {
+		return d.ModelName != "" && strings.Contains(strings.ToLower(d.ModelName), strings.ToLower(model))
+	}
+}
+
+// ByUUID matches the device having the given UUID
+func ByUUID(uuid string) func(*HPDevice) bool {
+	return func(d *HPDevice) bool {
+		return d.UUID != "" && strings.EqualFold(d.UUID, uuid)
+	}
+}
+
+// LocalizeDeviceWith returns the first discovered device accepted by match, see ByName, BySerial, ByModel and ByUUID
+func LocalizeDeviceWith(match func(*HPDevice) bool) (d *HPDevice, err error) {
+	return localize("LocalizeDeviceWith", match)
+}
+
+// localize tries the registered devices before probing the network. When a
+// registered device is used, the registry is refreshed in background, see RegistryRefreshInterval.
+func localize(operation string, match func(*HPDevice) bool) (d *HPDevice, err error) {
+	if d = cachedDevice(match); d != nil {
+		refreshRegistry()
+		return d, nil
+	}
+	devices, err := DiscoverDevices()
+	if err != nil {
+		return nil, err
+	}
+	return selectDevice(operation, devices, match)
+}
+
+// cachedDevice returns the most recently seen registered device accepted by match and still answering.
+// The devices are probed at once, stale entries don't slow down the lookup.
+func cachedDevice(match func(*HPDevice) bool) *HPDevice {
+	if DefaultRegistry == nil {
+		return nil
+	}
+	var candidates []*HPDevice
+	for _, e := range DefaultRegistry.Entries() {
+		if d := e.Device(); match(d) {
+			candidates = append(candidates, d)
+		}
+	}
+	answering := make([]bool, len(candidates))
+	var wg sync.WaitGroup
+	for i, d := range candidates {
+		wg.Add(1)
+		go func(i int, d *HPDevice) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), EndpointTimeout)
+			defer cancel()
+			_, err := d.tryEndpoint(ctx, d.URL)
+			answering[i] = err == nil
+		}(i, d)
+	}
+	wg.Wait()
+	for i, d := range candidates {
+		if answering[i] {
+			return d
+		}
+	}
+	return nil
+}
+
+func selectDevice(operation string, devices []*HPDevice, match func(*HPDevice) bool) (*HPDevice, error) {
+	if len(devices) == 0 {
+		return nil, NewHPDeviceError(operation, "No device found")
+	}
+	for _, d := range devices {
+		if match(d) {
+			return d, nil
+		}
+	}
+	return nil, NewHPDeviceError(operation, "No matching device among "+strings.Join(deviceNames(devices), ", "))
+}
+
+func deviceNames(devices []*HPDevice) []string {
+	names := make([]string, len(devices))
+	for i, d := range devices {
+		names[i] = d.String()
+	}
+	return names
+}
+
+func shortHostName(name string) string {
+	if i := strings.Index(name, "."); i > 0 {
+		return name[:i]
 	}
-	return nil, err
+	return name
 }
@@ -0,0 +1,26 @@
+package hpdevices
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	discard := log.New(ioutil.Discard, "", 0)
+	InitLogger(discard, discard, discard, discard)
+	// Keep the user registry out of tests
+	dir, err := ioutil.TempDir("", "hpdevices")
+	if err != nil {
+		panic(err)
+	}
+	DefaultRegistry = NewRegistry(filepath.Join(dir, "devices.json"))
+	// Nor the network, background registry refreshes discover nothing
+	DefaultDiscoverer = staticDiscoverer{}
+	code := m.Run()
+	refreshes.Wait()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
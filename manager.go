@@ -1,14 +1,32 @@
 package hpdevices
 
 import (
+	"context"
 	"encoding/xml"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
 	"strings"
+	"time"
 )
 
 type HPDevice struct {
-	URL string
+	URL          string
+	IPAddress    string // Address the device has answered from during discovery
+	HostName     string
+	ModelName    string
+	SerialNumber string
+	UUID         string
+	ScanResource string       // eSCL resource root announced with mDNS
+	Client       *http.Client // HTTP client used with the device, default is http.DefaultClient
+
+	ScanValidation ScanValidation // How scan jobs are checked against the device capabilities
+	JobPolling     JobPolling     // How scan jobs are followed, DefaultJobPolling when zero
+	PageDownload   PageDownload   // How pages are downloaded, DefaultPageDownload when zero
+	BusyTimeout    time.Duration  // Wait for a busy scanner before posting a job, DefaultBusyTimeout when zero
+
+	resources *resourceCache // Resources advertised by the device, see endpoint
 }
 
 type HPDeviceError struct {
@@ -26,6 +44,11 @@ func (e HPDeviceError) Error() string {
 	}
 }
 
+// Unwrap gives the underlying error, for errors.Is and errors.As
+func (e HPDeviceError) Unwrap() error {
+	return e.Err
+}
+
 func NewHPDeviceError(operation, message string, err ...error) error {
 	e := HPDeviceError{operation, message, nil}
 	if len(err) > 0 {
@@ -35,25 +58,114 @@ func NewHPDeviceError(operation, message string, err ...error) error {
 	return e
 }
 
+// NewHPDevice creates the device served at url. The identity remembered by
+// the registry is used, and refreshed in background.
 func NewHPDevice(url string) (d *HPDevice, err error) {
-	d = &HPDevice{url}
+	d = &HPDevice{URL: url}
+	if DefaultRegistry != nil {
+		if e, ok := DefaultRegistry.LookupURL(url); ok {
+			d = e.Device()
+		}
+	}
 	err = d.IsOnLine()
 	if err != nil {
+		if d.UUID != "" {
+			// The device may have changed of address
+			if moved, lerr := LocalizeDeviceByUUID(d.UUID); lerr == nil {
+				return moved, nil
+			}
+		}
 		return nil, err
 	}
+	refreshDevice(d)
 	return d, err
 }
 
 func (d *HPDevice) IsOnLine() (err error) {
-	resp, err := http.Get(d.URL + "/DevMgmt/DiscoveryTree.xml")
+	return d.isOnLine(context.Background())
+}
+
+func (d *HPDevice) isOnLine(ctx context.Context) (err error) {
+	req, err := http.NewRequest("GET", d.URL+"/DevMgmt/DiscoveryTree.xml", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := d.client().Do(req.WithContext(ctx))
 	if resp != nil {
 		resp.Body.Close()
 	}
 	return err
 }
 
+// Identify fills the device identity from its product configuration
+func (d *HPDevice) Identify() (err error) {
+	resp, err := d.client().Get(d.endpoint(context.Background(), productConfigResource))
+	if err != nil {
+		return NewHPDeviceError("HPDevice.Identify", "", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return NewHPDeviceError("HPDevice.Identify", "Unexpected status "+resp.Status, nil)
+	}
+	buffer, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return NewHPDeviceError("HPDevice.Identify", "ReadAll", err)
+	}
+	config := new(productConfigDyn)
+	err = xml.Unmarshal(buffer, config)
+	if err != nil {
+		return NewHPDeviceError("HPDevice.Identify", "Unmarshal", err)
+	}
+	if config.MakeAndModel != "" {
+		d.ModelName = config.MakeAndModel
+	}
+	if config.SerialNumber != "" {
+		d.SerialNumber = config.SerialNumber
+	}
+	if config.UUID != "" {
+		d.UUID = strings.ToLower(config.UUID)
+	}
+
+	if d.HostName == "" && d.IPAddress != "" {
+		if names, err := net.LookupAddr(d.IPAddress); err == nil && len(names) > 0 {
+			d.HostName = strings.TrimSuffix(names[0], ".")
+		}
+	}
+	return nil
+}
+
+// resolve gives the absolute URL of a reference returned by the device,
+// like a Location header or a BinaryURL
+func (d *HPDevice) resolve(ref string) string {
+	base, err := url.Parse(d.URL)
+	if err != nil {
+		return d.URL + ref
+	}
+	u, err := base.Parse(ref)
+	if err != nil {
+		return d.URL + ref
+	}
+	return u.String()
+}
+
+// String gives the most meaningful name known for the device
+func (d *HPDevice) String() string {
+	name := d.ModelName
+	if name == "" {
+		name = "HPDevice"
+	}
+	switch {
+	case d.HostName != "":
+		return name + " (" + d.HostName + ")"
+	case d.IPAddress != "":
+		return name + " (" + d.IPAddress + ")"
+	}
+	return name + " (" + d.URL + ")"
+}
+
 func (d *HPDevice) getStatus() (*scanStatus, error) {
-	resp, err := http.Get(d.URL + "/Scan/Status")
+	resp, err := d.client().Get(d.endpoint(context.Background(), scanStatusResource))
 	if err != nil {
 		return nil, NewHPDeviceError("HPDevice.getStatus", "", err)
 	}
@@ -74,6 +186,16 @@ func (d *HPDevice) getStatus() (*scanStatus, error) {
 	return status, err
 }
 
+// readScanStatus reads the scanner state, the request is bound to the context
+func (d *HPDevice) readScanStatus(ctx context.Context) (*scanStatus, error) {
+	status := new(scanStatus)
+	err := d.getXML(ctx, d.endpoint(ctx, scanStatusResource), status)
+	if err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
 func (d *HPDevice) GetSource() (source string, err error) {
 
 	status, err := d.getStatus()
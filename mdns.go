@@ -0,0 +1,227 @@
+// mdns.go
+package hpdevices
+
+import (
+	"math/rand"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const mdnsMulticastAddr = "224.0.0.251:5353"
+
+// Services announced by HP printers and scanners
+var mdnsServices = []string{"_uscan._tcp.local.", "_ipp._tcp.local.", "_pdl-datastream._tcp.local."}
+
+// MDNSDiscoverer finds devices with multicast DNS service discovery (DNS-SD).
+// The query is sent from an ephemeral port, so responders answer directly to us.
+// Only the instances giving HP as manufacturer in their TXT record are kept.
+type MDNSDiscoverer struct {
+	Addr     string   // Destination of queries, default is the mDNS multicast group
+	Services []string // Service types to query, default are HP announced services
+}
+
+// mdnsInstance collects the records of one service instance
+type mdnsInstance struct {
+	name   string            // Instance name, ending with the service type
+	target string            // Host name from SRV record
+	port   int               // Port from SRV record
+	txt    map[string]string // TXT record
+	from   net.IP            // Address of the responder
+}
+
+func (m *MDNSDiscoverer) Probe(window time.Duration) (devices []*HPDevice, err error) {
+	addr := m.Addr
+	if addr == "" {
+		addr = mdnsMulticastAddr
+	}
+	services := m.Services
+	if len(services) == 0 {
+		services = mdnsServices
+	}
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, NewHPDeviceError("MDNSDiscoverer.Probe", "ListenUDP", err)
+	}
+	defer conn.Close()
+	group, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		return nil, NewHPDeviceError("MDNSDiscoverer.Probe", "ResolveUDPAddr", err)
+	}
+	query, err := mdnsQuery(uint16(rand.Intn(0x10000)), services)
+	if err != nil {
+		return nil, NewHPDeviceError("MDNSDiscoverer.Probe", "Query", err)
+	}
+	_, err = conn.WriteToUDP(query, group)
+	if err != nil {
+		return nil, NewHPDeviceError("MDNSDiscoverer.Probe", "WriteToUDP", err)
+	}
+
+	instances := make(map[string]*mdnsInstance)
+	addresses := make(map[string]net.IP) // Host name to address
+	conn.SetReadDeadline(time.Now().Add(window))
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			// The deadline ends the probe window
+			break
+		}
+		parseMDNSResponse(buf[:n], from.IP, instances, addresses)
+	}
+
+	for _, instance := range instances {
+		if !instance.isHP() {
+			// Printers of other vendors announce the same services
+			continue
+		}
+		devices = mergeDevices(devices, instance.device(addresses))
+	}
+	return devices, nil
+}
+
+func mdnsQuery(id uint16, services []string) ([]byte, error) {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	for _, service := range services {
+		name, err := dnsmessage.NewName(service)
+		if err != nil {
+			return nil, err
+		}
+		err = b.Question(dnsmessage.Question{Name: name, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
+
+// parseMDNSResponse reads all sections of a response, malformed messages are ignored
+func parseMDNSResponse(msg []byte, from net.IP, instances map[string]*mdnsInstance, addresses map[string]net.IP) {
+	var p dnsmessage.Parser
+	header, err := p.Start(msg)
+	if err != nil || !header.Response {
+		return
+	}
+	if err = p.SkipAllQuestions(); err != nil {
+		return
+	}
+	instance := func(name string) *mdnsInstance {
+		i, ok := instances[name]
+		if !ok {
+			i = &mdnsInstance{name: name, txt: make(map[string]string), from: from}
+			instances[name] = i
+		}
+		return i
+	}
+
+	for {
+		h, err := p.AnswerHeader()
+		if err != nil {
+			break // ErrSectionDone, or a malformed message
+		}
+		if err = parseMDNSRecord(&p, h, p.SkipAnswer, instance, addresses); err != nil {
+			return
+		}
+	}
+	if err = p.SkipAllAuthorities(); err != nil {
+		return
+	}
+	for {
+		h, err := p.AdditionalHeader()
+		if err != nil {
+			return
+		}
+		if err = parseMDNSRecord(&p, h, p.SkipAdditional, instance, addresses); err != nil {
+			return
+		}
+	}
+}
+
+func parseMDNSRecord(p *dnsmessage.Parser, h dnsmessage.ResourceHeader, skip func() error, instance func(string) *mdnsInstance, addresses map[string]net.IP) error {
+	name := strings.ToLower(h.Name.String())
+	switch h.Type {
+	case dnsmessage.TypePTR:
+		r, err := p.PTRResource()
+		if err != nil {
+			return err
+		}
+		instance(strings.ToLower(r.PTR.String()))
+	case dnsmessage.TypeSRV:
+		r, err := p.SRVResource()
+		if err != nil {
+			return err
+		}
+		i := instance(name)
+		i.target, i.port = strings.ToLower(r.Target.String()), int(r.Port)
+	case dnsmessage.TypeTXT:
+		r, err := p.TXTResource()
+		if err != nil {
+			return err
+		}
+		i := instance(name)
+		for _, txt := range r.TXT {
+			if kv := strings.SplitN(txt, "=", 2); len(kv) == 2 {
+				i.txt[strings.ToLower(kv[0])] = kv[1]
+			}
+		}
+	case dnsmessage.TypeA:
+		r, err := p.AResource()
+		if err != nil {
+			return err
+		}
+		addresses[name] = net.IP(r.A[:])
+	default:
+		return skip()
+	}
+	return nil
+}
+
+// device builds the HPDevice announced by the instance
+func (i *mdnsInstance) device(addresses map[string]net.IP) *HPDevice {
+	d := &HPDevice{
+		ModelName:    i.txt["ty"],
+		UUID:         strings.ToLower(i.txt["uuid"]),
+		ScanResource: i.txt["rs"],
+	}
+	ip := i.from
+	if a, ok := addresses[i.target]; ok {
+		ip = a
+	}
+	if ip != nil {
+		d.IPAddress = ip.String()
+		if strings.HasSuffix(i.name, "._uscan._tcp.local.") && i.port > 0 {
+			// The scan service is served by the embedded web server, with the LEDM interface
+			d.URL = baseURL("http", d.IPAddress, i.port)
+		}
+	}
+	if i.target != "" {
+		d.HostName = strings.TrimSuffix(i.target, ".")
+	} else if u, err := url.Parse(i.txt["adminurl"]); err == nil && u.Hostname() != "" {
+		d.HostName = strings.TrimSuffix(u.Hostname(), ".")
+	}
+	return d
+}
+
+// isHP tells if the TXT record gives HP as manufacturer
+func (i *mdnsInstance) isHP() bool {
+	for _, key := range []string{"usb_mfg", "mfg", "ty", "product"} {
+		words := strings.FieldsFunc(strings.ToUpper(i.txt[key]), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		for _, w := range words {
+			if w == "HP" || w == "HEWLETT" {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package hpdevices
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// mdnsResponder answers to DNS-SD queries like an HP printer does
+func mdnsResponder(t *testing.T) (addr string, stop func()) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			var p dnsmessage.Parser
+			h, err := p.Start(buf[:n])
+			if err != nil {
+				continue
+			}
+			questions, err := p.AllQuestions()
+			if err != nil {
+				continue
+			}
+			for _, q := range questions {
+				if q.Name.String() != "_uscan._tcp.local." {
+					continue
+				}
+				conn.WriteToUDP(uscanResponse(t, h.ID), from)
+			}
+		}
+	}()
+	return conn.LocalAddr().String(), func() { conn.Close() }
+}
+
+func uscanResponse(t *testing.T, id uint16) []byte {
+	service := dnsmessage.MustNewName("_uscan._tcp.local.")
+	instance := dnsmessage.MustNewName("HP OfficeJet Pro 8600 [8F3E21]._uscan._tcp.local.")
+	host := dnsmessage.MustNewName("HP8F3E21.local.")
+	rh := func(name dnsmessage.Name) dnsmessage.ResourceHeader {
+		return dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET, TTL: 120}
+	}
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, Response: true, Authoritative: true})
+	b.StartAnswers()
+	b.PTRResource(rh(service), dnsmessage.PTRResource{PTR: instance})
+	b.StartAdditionals()
+	b.SRVResource(rh(instance), dnsmessage.SRVResource{Target: host, Port: 8080})
+	b.TXTResource(rh(instance), dnsmessage.TXTResource{TXT: []string{
+		"txtvers=1",
+		"ty=HP Officejet Pro 8600",
+		"UUID=1C852A4D-B800-1F08-ABCD-A0B3CC8F3E21",
+		"adminurl=http://HP8F3E21.local.",
+		"rs=eSCL",
+	}})
+	b.AResource(rh(host), dnsmessage.AResource{A: [4]byte{192, 168, 1, 42}})
+
+	// Another vendor on the same network
+	other := dnsmessage.MustNewName("Brother MFC-L2710DW._uscan._tcp.local.")
+	otherHost := dnsmessage.MustNewName("BRW1234.local.")
+	b.SRVResource(rh(other), dnsmessage.SRVResource{Target: otherHost, Port: 80})
+	b.TXTResource(rh(other), dnsmessage.TXTResource{TXT: []string{"ty=Brother MFC-L2710DW series", "usb_MFG=Brother", "rs=eSCL"}})
+	b.AResource(rh(otherHost), dnsmessage.AResource{A: [4]byte{192, 168, 1, 50}})
+	msg, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func Test_MDNSDiscoverer(t *testing.T) {
+	addr, stop := mdnsResponder(t)
+	defer stop()
+
+	m := &MDNSDiscoverer{Addr: addr}
+	devices, err := m.Probe(200 * time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 1 {
+		t.Fatalf("expected 1 device, got %d", len(devices))
+	}
+	d := devices[0]
+	if d.ModelName != "HP Officejet Pro 8600" || d.UUID != "1c852a4d-b800-1f08-abcd-a0b3cc8f3e21" ||
+		d.HostName != "hp8f3e21.local" || d.IPAddress != "192.168.1.42" || d.ScanResource != "eSCL" || d.URL != "http://192.168.1.42:8080" {
+		t.Errorf("unexpected device %+v", d)
+	}
+}
+
+type staticDiscoverer []*HPDevice
+
+func (s staticDiscoverer) Probe(time.Duration) ([]*HPDevice, error) { return s, nil }
+
+func Test_MultiDiscovererMerge(t *testing.T) {
+	slp := staticDiscoverer{{IPAddress: "192.168.1.42", URL: "http://192.168.1.42:8080"}, {IPAddress: "192.168.1.43"}}
+	mdns := staticDiscoverer{{IPAddress: "192.168.1.42", ModelName: "HP Officejet Pro 8600", UUID: "1c85"}}
+	devices, err := MultiDiscoverer{slp, mdns}.Probe(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 2 {
+		t.Fatalf("expected 2 devices, got %d", len(devices))
+	}
+	if devices[0].UUID != "1c85" || devices[0].URL != "http://192.168.1.42:8080" {
+		t.Errorf("devices not merged: %+v", devices[0])
+	}
+}
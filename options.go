@@ -0,0 +1,216 @@
+// options.go
+package hpdevices
+
+import (
+	"context"
+)
+
+// ScanOptions are the settings of a scan job. Areas are in device units, 1/300 of inch.
+type ScanOptions struct {
+	InputSource        InputSource
+	XResolution        int
+	YResolution        int
+	XStart             int
+	YStart             int
+	Width              int
+	Height             int
+	Format             string // Jpeg, Raw
+	CompressionQFactor int
+	ColorSpace         string // Gray, Color
+	BitDepth           int    // 1 or 8
+	GrayRendering      string // NTSC, GrayCcdEmulated
+	ToneMap            ToneMap
+	SharpeningLevel    int
+	NoiseRemoval       int
+	ContentType        string          // Document, Photo
+	Duplex             bool            // Both sides of the sheets, with the feeder
+	Validation         ScanValidation  // ValidationDefault uses the device ScanValidation
+	Rotation           RotationMode    // How pages are turned according to the device orientation
+	Processors         []PageProcessor // Applied in order to the decoded pages, before the writer
+}
+
+type ToneMap struct {
+	Gamma      int
+	Brightness int
+	Contrast   int
+	Highlite   int
+	Shadow     int
+	Threshold  int
+}
+
+func newToneMap(t toneMap) ToneMap {
+	return ToneMap{t.Gamma, t.Brightness, t.Contrast, t.Highlite, t.Shadow, t.Threshold}
+}
+
+func (t ToneMap) toneMap() toneMap {
+	return toneMap{Gamma: t.Gamma, Brightness: t.Brightness, Contrast: t.Contrast, Highlite: t.Highlite, Shadow: t.Shadow, Threshold: t.Threshold}
+}
+
+// ScanOption sets one or several fields of ScanOptions
+type ScanOption func(*ScanOptions)
+
+// NewScanOptions gives the default settings, modified by the given options
+func NewScanOptions(options ...ScanOption) ScanOptions {
+	ss := defautScanSetting()
+	o := ScanOptions{
+		InputSource:        InputSource(ss.InputSource),
+		XResolution:        ss.XResolution,
+		YResolution:        ss.YResolution,
+		XStart:             ss.XStart,
+		YStart:             ss.YStart,
+		Width:              ss.Width,
+		Height:             ss.Height,
+		Format:             ss.Format,
+		CompressionQFactor: ss.CompressionQFactor,
+		ColorSpace:         ss.ColorSpace,
+		BitDepth:           ss.BitDepth,
+		GrayRendering:      ss.GrayRendering,
+		ToneMap:            newToneMap(ss.ToneMap),
+		SharpeningLevel:    ss.SharpeningLevel,
+		NoiseRemoval:       ss.NoiseRemoval,
+		ContentType:        ss.ContentType,
+	}
+	for _, option := range options {
+		option(&o)
+	}
+	return o
+}
+
+func WithSource(source InputSource) ScanOption {
+	return func(o *ScanOptions) { o.InputSource = source }
+}
+
+// WithResolution sets the same resolution on both axis
+func WithResolution(dpi int) ScanOption {
+	return func(o *ScanOptions) { o.XResolution, o.YResolution = dpi, dpi }
+}
+
+// WithColorSpace sets Gray or Color, keeping the bit depth
+func WithColorSpace(colorSpace string) ScanOption {
+	return func(o *ScanOptions) { o.ColorSpace = colorSpace }
+}
+
+// WithBitDepth sets 8 bits, or 1 bit for black and white scans
+func WithBitDepth(bitDepth int) ScanOption {
+	return func(o *ScanOptions) { o.BitDepth = bitDepth }
+}
+
+// WithArea sets the scan area, in 1/300 of inch
+func WithArea(x, y, width, height int) ScanOption {
+	return func(o *ScanOptions) { o.XStart, o.YStart, o.Width, o.Height = x, y, width, height }
+}
+
+func WithFormat(format string) ScanOption {
+	return func(o *ScanOptions) { o.Format = format }
+}
+
+func WithCompressionQFactor(q int) ScanOption {
+	return func(o *ScanOptions) { o.CompressionQFactor = q }
+}
+
+func WithGrayRendering(rendering string) ScanOption {
+	return func(o *ScanOptions) { o.GrayRendering = rendering }
+}
+
+func WithToneMap(toneMap ToneMap) ScanOption {
+	return func(o *ScanOptions) { o.ToneMap = toneMap }
+}
+
+func WithSharpeningLevel(level int) ScanOption {
+	return func(o *ScanOptions) { o.SharpeningLevel = level }
+}
+
+func WithNoiseRemoval(level int) ScanOption {
+	return func(o *ScanOptions) { o.NoiseRemoval = level }
+}
+
+func WithContentType(contentType string) ScanOption {
+	return func(o *ScanOptions) { o.ContentType = contentType }
+}
+
+// WithDuplex scans both sides of the sheets put in the feeder
+func WithDuplex() ScanOption {
+	return func(o *ScanOptions) { o.InputSource, o.Duplex = SourceAdf, true }
+}
+
+func WithValidation(validation ScanValidation) ScanOption {
+	return func(o *ScanOptions) { o.Validation = validation }
+}
+
+func WithRotation(mode RotationMode) ScanOption {
+	return func(o *ScanOptions) { o.Rotation = mode }
+}
+
+// WithProcessors adds processors to the decoded pages, see PageProcessor
+func WithProcessors(processors ...PageProcessor) ScanOption {
+	return func(o *ScanOptions) { o.Processors = append(o.Processors, processors...) }
+}
+
+// scanSettings gives the settings posted to the device
+func (o *ScanOptions) scanSettings() scanSettings {
+	var adfOptions []string
+	if o.Duplex {
+		adfOptions = []string{"Duplex"}
+	}
+	return scanSettings{
+		XResolution:        o.XResolution,
+		YResolution:        o.YResolution,
+		XStart:             o.XStart,
+		YStart:             o.YStart,
+		Width:              o.Width,
+		Height:             o.Height,
+		Format:             o.Format,
+		CompressionQFactor: o.CompressionQFactor,
+		ColorSpace:         o.ColorSpace,
+		BitDepth:           o.BitDepth,
+		InputSource:        string(o.InputSource),
+		AdfOptions:         adfOptions,
+		GrayRendering:      o.GrayRendering,
+		ToneMap:            o.ToneMap.toneMap(),
+		SharpeningLevel:    o.SharpeningLevel,
+		NoiseRemoval:       o.NoiseRemoval,
+		ContentType:        o.ContentType,
+	}
+}
+
+// setScanSettings copies back settings, once snapped
+func (o *ScanOptions) setScanSettings(ss *scanSettings) {
+	o.XResolution, o.YResolution = ss.XResolution, ss.YResolution
+	o.XStart, o.YStart, o.Width, o.Height = ss.XStart, ss.YStart, ss.Width, ss.Height
+	o.Format = ss.Format
+	o.Duplex = contains(ss.AdfOptions, "Duplex")
+}
+
+// Validate checks the options against the capabilities. When snap is true,
+// options are moved to the nearest supported values.
+func (o *ScanOptions) Validate(caps *ScanCapabilities, snap bool) error {
+	ss := o.scanSettings()
+	err := validateScanSettings(caps, &ss, snap)
+	if snap {
+		o.setScanSettings(&ss)
+	}
+	return err
+}
+
+// Scan runs a scan job with the options, and gives each page to the writer.
+// It returns when the job is completed. Jobs of a device run one at a time, see StartScan.
+// See AdaptImageWriter for ImageWriters.
+func (d *HPDevice) Scan(ctx context.Context, opts ScanOptions, writer PageWriter) (err error) {
+	ss, err := d.scanSettings(ctx, opts)
+	if err != nil {
+		return NewHPDeviceError("HPDevice.Scan", "Settings", err)
+	}
+	sj := d.newScanJob(writer, opts)
+	return sj.run(ctx, ss)
+}
+
+// scanSettings gives the settings of the options, checked with the validation mode of the options or of the device
+func (d *HPDevice) scanSettings(ctx context.Context, opts ScanOptions) (ss scanSettings, err error) {
+	validation := opts.Validation
+	if validation == ValidationDefault {
+		validation = d.ScanValidation
+	}
+	ss = opts.scanSettings()
+	err = d.validateScan(ctx, &ss, validation)
+	return ss, err
+}
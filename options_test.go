@@ -0,0 +1,86 @@
+package hpdevices
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_ScanOptions(t *testing.T) {
+	o := NewScanOptions()
+	if ss := o.scanSettings(); !reflect.DeepEqual(ss, defautScanSetting()) {
+		t.Errorf("default options differ from default settings\n%+v\n%+v", ss, defautScanSetting())
+	}
+
+	o = NewScanOptions(
+		WithSource(SourceAdf),
+		WithResolution(300),
+		WithColorSpace("Color"),
+		WithArea(10, 20, 2400, 3300),
+		WithFormat("Raw"),
+		WithCompressionQFactor(25),
+		WithSharpeningLevel(2),
+		WithNoiseRemoval(1),
+		WithContentType("Photo"),
+		WithToneMap(ToneMap{Gamma: 1500, Brightness: 800, Contrast: 1200}),
+	)
+	buffer, err := xml.Marshal(o.scanSettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"<XResolution>300</XResolution>", "<InputSource>Adf</InputSource>", "<ColorSpace>Color</ColorSpace>",
+		"<XStart>10</XStart>", "<YStart>20</YStart>", "<Width>2400</Width>", "<Height>3300</Height>",
+		"<Format>Raw</Format>", "<CompressionQFactor>25</CompressionQFactor>", "<SharpeningLevel>2</SharpeningLevel>",
+		"<NoiseRemoval>1</NoiseRemoval>", "<ContentType>Photo</ContentType>", "<Gamma>1500</Gamma>",
+	} {
+		if !strings.Contains(string(buffer), want) {
+			t.Errorf("%s not found in %s", want, buffer)
+		}
+	}
+}
+
+func Test_ScanOptionsValidate(t *testing.T) {
+	caps := testScanCapabilities(t)
+	o := NewScanOptions(WithSource(SourceAdf), WithResolution(600))
+	if err := o.Validate(caps, false); err == nil {
+		t.Error("600 dpi accepted on the feeder")
+	}
+	if err := o.Validate(caps, true); err != nil || o.XResolution != 300 {
+		t.Errorf("snap got %d, %v", o.XResolution, err)
+	}
+}
+
+func Test_ScanRejectedBeforePosting(t *testing.T) {
+	posted := false
+	d := &HPDevice{URL: "http://device", resources: &resourceCache{resources: new(DeviceResources)}}
+	d.Client = &http.Client{Transport: handlerTransport{http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			posted = true
+		}
+		w.Write([]byte(scanCapsXML))
+	})}}
+	err := d.Scan(context.Background(), NewScanOptions(WithResolution(4800), WithValidation(ValidationStrict)), nil)
+	var e *ScanSettingsError
+	if !errors.As(err, &e) || posted {
+		t.Errorf("expected ScanSettingsError without posting, got %v", err)
+	}
+}
+
+func Test_ScanValidationOverride(t *testing.T) {
+	d := &HPDevice{URL: "http://device", ScanValidation: ValidationStrict, resources: &resourceCache{resources: new(DeviceResources)}}
+	d.Client = &http.Client{Transport: handlerTransport{http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(scanCapsXML))
+	})}}
+	var e *ScanSettingsError
+	if _, err := d.scanSettings(context.Background(), NewScanOptions(WithResolution(4800))); !errors.As(err, &e) {
+		t.Errorf("device validation not used, got %v", err)
+	}
+	if _, err := d.scanSettings(context.Background(), NewScanOptions(WithResolution(4800), WithValidation(ValidationNone))); err != nil {
+		t.Errorf("job without validation got %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+// page.go
+package hpdevices
+
+import (
+	"io"
+)
+
+// PageInfo describes a page given to a PageWriter, as announced by the device when the page is ready
+type PageInfo struct {
+	PageNumber      int
+	Width           int // Pixels
+	Height          int // Pixels, can be wrong for JPEG pages scanned with the ADF
+	BytesPerLine    int
+	XResolution     int
+	YResolution     int
+	ColorSpace      string // Gray, Color
+	BitDepth        int
+	InputSource     InputSource
+	Side            PageSide
+	Sheet           int     // Sheet number, pages of duplex scans go by two
+	Orientation     string  // ImageOrientation given by the device, like Normal
+	Rotation        int     // Clockwise rotation applied to the image, in degrees
+	EXIFOrientation int     // EXIF orientation tag added to the JPEG stream, 0 when none
+	Format          string  // Format of the stream given to NewPage: Jpeg, Png or Tiff
+	InkCoverage     float64 // Ratio of ink pixels, set by BlankPageFilter
+	Blank           bool    // Blank page kept by BlankPageFilter
+	Skew            float64 // Clockwise skew of the document corrected by AutoCrop, in degrees
+	Part            int     // Document of a page split by AutoCrop, from 1
+}
+
+// PageSide tells which side of the sheet a page is
+type PageSide string
+
+const (
+	FrontSide PageSide = "Front"
+	BackSide  PageSide = "Back"
+)
+
+// PageResult is reported by the device once the page is finished
+type PageResult struct {
+	PageNumber int
+	PageState  string // UploadCompleted, CanceledByDevice
+	TotalLines int
+}
+
+// PageWriter receives the pages of a scan job. Pages are downloaded and kept until the
+// device reports they are finished. Once they have passed the integrity checks, see
+// PageFailureHandler, NewPage is called and the page is written at once, then EndPage
+// is called with the result of the device. NewPage is called for each page given by the
+// PageProcessors, and EndPage once for them, or not at all when they all have been dropped.
+type PageWriter interface {
+	NewPage(info PageInfo) (io.WriteCloser, error)
+	EndPage(result PageResult) error
+}
+
+// AdaptImageWriter gives a PageWriter writing pages with an ImageWriter.
+// Page information and results are ignored.
+func AdaptImageWriter(w ImageWriter) PageWriter {
+	return imageWriterPages{w}
+}
+
+type imageWriterPages struct {
+	ImageWriter
+}
+
+func (p imageWriterPages) NewPage(info PageInfo) (io.WriteCloser, error) { return p.NewImageWriter() }
+func (p imageWriterPages) EndPage(result PageResult) error               { return nil }
+
+// newPageInfo builds the page information. Settings reported by the device
+// in the buffer information take precedence over the posted ones.
+func newPageInfo(page *preScanPage, posted scanSettings) PageInfo {
+	ss := page.BufferInfo.ScanSettings
+	if ss.XResolution == 0 {
+		ss = posted
+	}
+	if ss.InputSource == "" {
+		ss.InputSource = posted.InputSource
+	}
+	side, sheet := FrontSide, page.PageNumber
+	if isDuplex(&posted) {
+		// The device gives the front, then the back of each sheet
+		sheet = (page.PageNumber + 1) / 2
+		if page.PageNumber%2 == 0 {
+			side = BackSide
+		}
+	}
+	return PageInfo{
+		PageNumber:   page.PageNumber,
+		Width:        page.BufferInfo.ImageWidth,
+		Height:       page.BufferInfo.ImageHeight,
+		BytesPerLine: page.BufferInfo.BytesPerLine,
+		XResolution:  ss.XResolution,
+		YResolution:  ss.YResolution,
+		ColorSpace:   ss.ColorSpace,
+		BitDepth:     ss.BitDepth,
+		InputSource:  InputSource(ss.InputSource),
+		Side:         side,
+		Sheet:        sheet,
+		Orientation:  page.ImageOrientation,
+		Format:       ss.Format,
+	}
+}
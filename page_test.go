@@ -0,0 +1,135 @@
+package hpdevices
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"testing"
+)
+
+func Test_PageWriter(t *testing.T) {
+	fastPolling(t)
+	f := newFakeScanner(t, 2)
+	w := new(memoryPageWriter)
+	opts := NewScanOptions(WithResolution(300), WithColorSpace("Color"))
+	if err := f.device().Scan(context.Background(), opts, w); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.infos) != 2 || len(w.results) != 2 {
+		t.Fatalf("expected 2 pages and 2 results, got %+v %+v", w.infos, w.results)
+	}
+	expected := PageInfo{
+		PageNumber:   2,
+		Width:        30,
+		Height:       f.height,
+		BytesPerLine: 30,
+		XResolution:  300,
+		YResolution:  300,
+		ColorSpace:   "Color",
+		BitDepth:     8,
+		InputSource:  SourcePlaten,
+		Side:         FrontSide,
+		Sheet:        2,
+		Orientation:  "Normal",
+		Format:       "Jpeg",
+	}
+	if w.infos[1] != expected {
+		t.Errorf("unexpected page information %+v", w.infos[1])
+	}
+	if w.results[1] != (PageResult{2, "UploadCompleted", f.height}) {
+		t.Errorf("unexpected page result %+v", w.results[1])
+	}
+}
+
+func Test_NewPageInfo(t *testing.T) {
+	posted := defautScanSetting()
+	page := &preScanPage{PageNumber: 1, BufferInfo: bufferInfo{ScanSettings: defautScanSetting(), ImageWidth: 10, ImageHeight: 20}}
+	page.BufferInfo.ScanSettings.XResolution = 600
+	page.BufferInfo.ScanSettings.InputSource = ""
+	info := newPageInfo(page, posted)
+	if info.XResolution != 600 || info.InputSource != SourcePlaten || info.Width != 10 || info.Height != 20 {
+		t.Errorf("unexpected page information %+v", info)
+	}
+}
+
+// countingImageWriter is an ImageWriter of the first API
+type countingImageWriter struct {
+	pages []*bytes.Buffer
+}
+
+func (c *countingImageWriter) NewImageWriter() (io.WriteCloser, error) {
+	b := new(bytes.Buffer)
+	c.pages = append(c.pages, b)
+	return nopWriteCloser{b}, nil
+}
+
+func Test_AdaptImageWriter(t *testing.T) {
+	fastPolling(t)
+	f := newFakeScanner(t, 2)
+	w := new(countingImageWriter)
+	if err := f.device().Scan(context.Background(), NewScanOptions(), AdaptImageWriter(w)); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.pages) != 2 || !bytes.Equal(w.pages[0].Bytes(), f.pages[0]) {
+		t.Errorf("pages not written")
+	}
+}
+
+func Test_DuplexScan(t *testing.T) {
+	fastPolling(t)
+	f := newFakeScanner(t, 4)
+	// Back sides come upside down: dark on the bottom half
+	img := image.NewGray(image.Rect(0, 0, 30, f.height))
+	for i := len(img.Pix) / 2; i < len(img.Pix); i++ {
+		img.Pix[i] = 0
+	}
+	for i := 0; i < len(img.Pix)/2; i++ {
+		img.Pix[i] = 0xff
+	}
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	f.pages[1] = b.Bytes()
+	f.orientations = map[int]string{2: "Flipped", 4: "Flipped"}
+
+	w := new(memoryPageWriter)
+	if err := f.device().Scan(context.Background(), NewScanOptions(WithDuplex(), WithRotation(RotatePixels)), w); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.infos) != 4 {
+		t.Fatalf("expected 4 pages, got %d", len(w.infos))
+	}
+	for i, expected := range []struct {
+		side     PageSide
+		sheet    int
+		rotation int
+	}{{FrontSide, 1, 0}, {BackSide, 1, 180}, {FrontSide, 2, 0}, {BackSide, 2, 180}} {
+		info := w.infos[i]
+		if info.Side != expected.side || info.Sheet != expected.sheet || info.Rotation != expected.rotation || info.InputSource != SourceAdf {
+			t.Errorf("unexpected page information %+v", info)
+		}
+	}
+	back, err := jpeg.Decode(w.pages[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if top := color.GrayModel.Convert(back.At(15, 2)).(color.Gray); top.Y > 0x40 {
+		t.Errorf("back side not rotated, top is %v", top)
+	}
+}
+
+func Test_DuplexUnsupported(t *testing.T) {
+	fastPolling(t)
+	f := newFakeScanner(t, 1)
+	opts := NewScanOptions(WithDuplex(), WithSource(SourcePlaten))
+	err := f.device().Scan(context.Background(), opts, new(memoryPageWriter))
+	var settingsErr *ScanSettingsError
+	if !errors.As(err, &settingsErr) || settingsErr.Violations[0].Setting != "Duplex" || f.posted != 0 {
+		t.Errorf("duplex on the platen not rejected: %v", err)
+	}
+}
@@ -0,0 +1,202 @@
+// paper.go
+package hpdevices
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DeviceUnitsPerInch is the unit of scan areas and of ScanCaps sizes
+const DeviceUnitsPerInch = 300
+
+// Length is a physical length, in millimetres
+type Length float64
+
+const (
+	Millimetre Length = 1
+	Centimetre Length = 10
+	Inch       Length = 25.4
+)
+
+func Millimetres(mm float64) Length { return Length(mm) }
+func Inches(in float64) Length      { return Length(in) * Inch }
+
+// FromDeviceUnits converts a length given in 1/300 of inch
+func FromDeviceUnits(units int) Length {
+	return Length(float64(units)/DeviceUnitsPerInch) * Inch
+}
+
+// FromPixels converts a number of pixels at the resolution
+func FromPixels(pixels, dpi int) Length {
+	return Length(float64(pixels)/float64(dpi)) * Inch
+}
+
+func (l Length) Millimetres() float64 { return float64(l) }
+func (l Length) Inches() float64      { return float64(l / Inch) }
+
+// DeviceUnits converts the length in 1/300 of inch
+func (l Length) DeviceUnits() int {
+	return int(math.Round(l.Inches() * DeviceUnitsPerInch))
+}
+
+// Pixels gives the number of pixels covered by the length at the resolution
+func (l Length) Pixels(dpi int) int {
+	return int(math.Round(l.Inches() * float64(dpi)))
+}
+
+// Region is a scan area, from the top left corner of the source
+type Region struct {
+	X      Length
+	Y      Length
+	Width  Length
+	Height Length
+}
+
+// RegionMM builds a region from millimetres
+func RegionMM(x, y, width, height float64) Region {
+	return Region{Millimetres(x), Millimetres(y), Millimetres(width), Millimetres(height)}
+}
+
+// RegionInches builds a region from inches
+func RegionInches(x, y, width, height float64) Region {
+	return Region{Inches(x), Inches(y), Inches(width), Inches(height)}
+}
+
+// DeviceArea converts the region in 1/300 of inch
+func (r Region) DeviceArea() (x, y, width, height int) {
+	return r.X.DeviceUnits(), r.Y.DeviceUnits(), r.Width.DeviceUnits(), r.Height.DeviceUnits()
+}
+
+// ClampRegion converts the region in 1/300 of inch, fitted into the source
+func (s *SourceCapabilities) ClampRegion(r Region) (x, y, width, height int) {
+	x, y, width, height = r.DeviceArea()
+	x, width = clampSpan(x, width, s.MinWidth, s.MaxWidth)
+	y, height = clampSpan(y, height, s.MinHeight, s.MaxHeight)
+	return x, y, width, height
+}
+
+// WithRegion sets the scan area from a physical region
+func WithRegion(r Region) ScanOption {
+	return func(o *ScanOptions) { o.XStart, o.YStart, o.Width, o.Height = r.DeviceArea() }
+}
+
+// WithPaperSize sets the scan area to the paper size, placed at the top left corner
+func WithPaperSize(p PaperSize) ScanOption {
+	return WithRegion(p.Region())
+}
+
+// PaperSize is a named paper format, in portrait orientation
+type PaperSize struct {
+	Name   string
+	Width  Length
+	Height Length
+}
+
+// Region places the paper at the top left corner of the source
+func (p PaperSize) Region() Region {
+	return Region{Width: p.Width, Height: p.Height}
+}
+
+// Landscape gives the paper size turned by 90 degrees
+func (p PaperSize) Landscape() PaperSize {
+	return PaperSize{p.Name, p.Height, p.Width}
+}
+
+var (
+	paperSizesMutex sync.Mutex
+	paperSizes      = map[string]PaperSize{}
+)
+
+func init() {
+	for _, p := range []PaperSize{
+		{"A4", Millimetres(210), Millimetres(297)},
+		{"A5", Millimetres(148), Millimetres(210)},
+		{"A6", Millimetres(105), Millimetres(148)},
+		{"Letter", Inches(8.5), Inches(11)},
+		{"Legal", Inches(8.5), Inches(14)},
+		{"BusinessCard", Millimetres(55), Millimetres(85)},
+		{"Photo10x15", Millimetres(100), Millimetres(150)},
+	} {
+		RegisterPaperSize(p)
+	}
+}
+
+// RegisterPaperSize adds a paper size to the catalogue, or replaces the one having the same name.
+// Sizes given in landscape orientation are turned in portrait.
+func RegisterPaperSize(p PaperSize) {
+	if p.Width > p.Height {
+		p = p.Landscape()
+	}
+	paperSizesMutex.Lock()
+	defer paperSizesMutex.Unlock()
+	paperSizes[strings.ToLower(p.Name)] = p
+}
+
+// LookupPaperSize returns the paper size, names are case insensitive
+func LookupPaperSize(name string) (PaperSize, bool) {
+	paperSizesMutex.Lock()
+	defer paperSizesMutex.Unlock()
+	p, ok := paperSizes[strings.ToLower(name)]
+	return p, ok
+}
+
+// PaperSizes lists the catalogue, sorted by name
+func PaperSizes() []PaperSize {
+	paperSizesMutex.Lock()
+	defer paperSizesMutex.Unlock()
+	list := make([]PaperSize, 0, len(paperSizes))
+	for _, p := range paperSizes {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// paperSizeConfig is a preset in a configuration file, like
+// {"Name": "Receipt", "Width": 80, "Height": 200, "Unit": "mm"}
+type paperSizeConfig struct {
+	Name   string
+	Width  float64
+	Height float64
+	Unit   string // mm, cm or in. Default is mm
+}
+
+// LoadPaperSizes registers the presets read from a JSON array, in portrait orientation
+func LoadPaperSizes(r io.Reader) error {
+	buffer, err := ioutil.ReadAll(r)
+	if err != nil {
+		return NewHPDeviceError("LoadPaperSizes", "ReadAll", err)
+	}
+	var configs []paperSizeConfig
+	err = json.Unmarshal(buffer, &configs)
+	if err != nil {
+		return NewHPDeviceError("LoadPaperSizes", "Unmarshal", err)
+	}
+	sizes := make([]PaperSize, 0, len(configs))
+	for _, c := range configs {
+		var unit Length
+		switch strings.ToLower(c.Unit) {
+		case "", "mm":
+			unit = Millimetre
+		case "cm":
+			unit = Centimetre
+		case "in", "inch":
+			unit = Inch
+		default:
+			return NewHPDeviceError("LoadPaperSizes", "Unknown unit "+c.Unit+" for "+c.Name)
+		}
+		if c.Name == "" || c.Width <= 0 || c.Height <= 0 {
+			return NewHPDeviceError("LoadPaperSizes", "Invalid preset "+c.Name)
+		}
+		sizes = append(sizes, PaperSize{c.Name, Length(c.Width) * unit, Length(c.Height) * unit})
+	}
+	for _, p := range sizes {
+		RegisterPaperSize(p)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package hpdevices
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_PaperSizes(t *testing.T) {
+	a4, ok := LookupPaperSize("a4")
+	if !ok {
+		t.Fatal("A4 not found")
+	}
+	// The former magic default area
+	if x, y, w, h := a4.Region().DeviceArea(); x != 0 || y != 0 || w != 2480 || h != 3508 {
+		t.Errorf("A4 device area %d,%d %dx%d", x, y, w, h)
+	}
+	letter, _ := LookupPaperSize("Letter")
+	if w, h := letter.Width.DeviceUnits(), letter.Height.DeviceUnits(); w != 2550 || h != 3300 {
+		t.Errorf("Letter device area %dx%d", w, h)
+	}
+	// Sizes are in portrait orientation
+	for _, p := range PaperSizes() {
+		if p.Width > p.Height {
+			t.Errorf("%s is in landscape orientation", p.Name)
+		}
+	}
+	if px := Millimetres(100).Pixels(600); px != 2362 {
+		t.Errorf("100 mm at 600 dpi gives %d pixels", px)
+	}
+	if l := FromDeviceUnits(300); l.Inches() != 1 {
+		t.Errorf("300 device units gives %v inches", l.Inches())
+	}
+}
+
+func Test_ClampRegion(t *testing.T) {
+	caps := testScanCapabilities(t)
+	platen, _ := caps.Source(SourcePlaten)
+	legal, _ := LookupPaperSize("Legal")
+	if x, y, w, h := platen.ClampRegion(legal.Region()); x != 0 || y != 0 || w != 2550 || h != 3508 {
+		t.Errorf("Legal clamped on platen %d,%d %dx%d", x, y, w, h)
+	}
+	card := RegionMM(200, 10, 85, 55)
+	if x, _, w, _ := platen.ClampRegion(card); x != 2550-1004 || w != 1004 {
+		t.Errorf("card clamped on platen x=%d w=%d", x, w)
+	}
+	o := NewScanOptions(WithRegion(RegionInches(1, 1, 2, 3.5)))
+	if o.XStart != 300 || o.YStart != 300 || o.Width != 600 || o.Height != 1050 {
+		t.Errorf("WithRegion %+v", o)
+	}
+}
+
+// keepPaperSizes restores the catalogue at the end of the test
+func keepPaperSizes(t *testing.T) {
+	paperSizesMutex.Lock()
+	saved := make(map[string]PaperSize, len(paperSizes))
+	for name, p := range paperSizes {
+		saved[name] = p
+	}
+	paperSizesMutex.Unlock()
+	t.Cleanup(func() {
+		paperSizesMutex.Lock()
+		paperSizes = saved
+		paperSizesMutex.Unlock()
+	})
+}
+
+func Test_LoadPaperSizes(t *testing.T) {
+	keepPaperSizes(t)
+	err := LoadPaperSizes(strings.NewReader(`[
+		{"Name": "Receipt", "Width": 80, "Height": 200},
+		{"Name": "Index card", "Width": 3, "Height": 5, "Unit": "in"},
+		{"Name": "Postcard", "Width": 6, "Height": 4, "Unit": "in"}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, ok := LookupPaperSize("receipt"); !ok || p.Width.DeviceUnits() != 945 {
+		t.Errorf("Receipt %+v", p)
+	}
+	if p, ok := LookupPaperSize("Index card"); !ok || p.Width.DeviceUnits() != 900 {
+		t.Errorf("Index card %+v", p)
+	}
+	if p, ok := LookupPaperSize("Postcard"); !ok || p.Width.DeviceUnits() != 1200 || p.Height.DeviceUnits() != 1800 {
+		t.Errorf("Postcard not turned in portrait %+v", p)
+	}
+	if err = LoadPaperSizes(strings.NewReader(`[{"Name": "Bad", "Width": 1, "Height": 1, "Unit": "furlong"}]`)); err == nil {
+		t.Error("unknown unit accepted")
+	}
+}
@@ -0,0 +1,141 @@
+// polling.go
+package hpdevices
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// JobPolling tells how the state of a scan job is followed. The job is queried
+// immediately, then after Min. The interval doubles each time nothing changes, up to Max.
+type JobPolling struct {
+	Min time.Duration
+	Max time.Duration
+	// When the device has an event table, waits are made with its long-poll,
+	// and the job is queried as soon as an event occurs. NoLongPoll disables it.
+	NoLongPoll bool
+}
+
+// DefaultJobPolling is used by devices having no JobPolling
+var DefaultJobPolling = JobPolling{Min: 200 * time.Millisecond, Max: 5 * time.Second}
+
+// longPollMargin is given to the device to answer a long-poll after its timeout
+const longPollMargin = 5 * time.Second
+
+// jobPoller paces the queries of a job
+type jobPoller struct {
+	JobPolling
+	interval time.Duration
+	events   *eventWaiter // nil when the long-poll isn't used
+}
+
+func (d *HPDevice) newJobPoller(ctx context.Context) *jobPoller {
+	p := &jobPoller{JobPolling: d.JobPolling}
+	if p.Min <= 0 {
+		p.Min = DefaultJobPolling.Min
+	}
+	if p.Max <= 0 {
+		p.Max = DefaultJobPolling.Max
+	}
+	if p.Max < p.Min {
+		p.Max = p.Min
+	}
+	p.interval = p.Min
+	if !p.NoLongPoll {
+		p.events = d.newEventWaiter(ctx)
+	}
+	return p
+}
+
+// next sets the interval before the following query
+func (p *jobPoller) next(changed bool) {
+	if changed {
+		p.interval = p.Min
+		return
+	}
+	p.interval *= 2
+	if p.interval > p.Max {
+		p.interval = p.Max
+	}
+}
+
+// wait returns after the interval, or earlier when the device reports an event
+func (p *jobPoller) wait(ctx context.Context) error {
+	if p.events != nil {
+		err := p.events.wait(ctx, p.interval)
+		if err == nil || ctx.Err() != nil {
+			return ctx.Err()
+		}
+		TRACE.Println("jobPoller: long-poll abandoned,", err)
+		p.events = nil
+	}
+	t := time.NewTimer(p.interval)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// eventWaiter long-polls the event table of the device
+type eventWaiter struct {
+	d    *HPDevice
+	url  string
+	etag string // Last version of the table known
+}
+
+// newEventWaiter reads the current version of the event table, it returns nil when the device doesn't have one
+func (d *HPDevice) newEventWaiter(ctx context.Context) *eventWaiter {
+	w := &eventWaiter{d: d, url: d.endpoint(ctx, eventTableResource)}
+	resp, err := w.get(ctx, w.url)
+	if err != nil {
+		TRACE.Println("eventWaiter: no event table,", err)
+		return nil
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 || resp.Header.Get("Etag") == "" {
+		TRACE.Println("eventWaiter: no event table, status", resp.Status)
+		return nil
+	}
+	w.etag = resp.Header.Get("Etag")
+	return w
+}
+
+// wait returns when the event table has changed, or after the timeout
+func (w *eventWaiter) wait(ctx context.Context, timeout time.Duration) error {
+	tenths := int(timeout / (100 * time.Millisecond))
+	if tenths < 1 {
+		tenths = 1
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout+longPollMargin)
+	defer cancel()
+	resp, err := w.get(ctx, w.url+"?timeout="+strconv.Itoa(tenths))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case 304: // Nothing new
+		return nil
+	case 200:
+		w.etag = resp.Header.Get("Etag")
+		TRACE.Println("eventWaiter: event table changed, version", w.etag)
+		return nil
+	}
+	return HPDeviceError{"eventWaiter.wait", "Unexpected status " + resp.Status, nil}
+}
+
+func (w *eventWaiter) get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if w.etag != "" {
+		req.Header.Set("If-None-Match", w.etag)
+	}
+	return w.d.client().Do(req.WithContext(ctx))
+}
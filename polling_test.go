@@ -0,0 +1,90 @@
+package hpdevices
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_JobPollerBackoff(t *testing.T) {
+	d := &HPDevice{JobPolling: JobPolling{Min: 100 * time.Millisecond, Max: time.Second, NoLongPoll: true}}
+	p := d.newJobPoller(context.Background())
+	var intervals []time.Duration
+	for _, changed := range []bool{false, false, false, false, false, true, false} {
+		p.next(changed)
+		intervals = append(intervals, p.interval)
+	}
+	expected := []time.Duration{200, 400, 800, 1000, 1000, 100, 200}
+	for i := range expected {
+		if intervals[i] != expected[i]*time.Millisecond {
+			t.Fatalf("unexpected intervals %v", intervals)
+		}
+	}
+
+	p = (&HPDevice{}).newJobPoller(context.Background())
+	if p.Min != DefaultJobPolling.Min || p.Max != DefaultJobPolling.Max || p.events != nil {
+		t.Errorf("defaults not applied %+v", p)
+	}
+}
+
+func Test_JobLongPoll(t *testing.T) {
+	f := newFakeScanner(t, 2)
+	f.prepare = 200 * time.Millisecond
+	f.events = true
+	d := f.device()
+	// Without events, the second page would wait for the maximum interval
+	d.JobPolling = JobPolling{Min: 3 * time.Second, Max: 3 * time.Second}
+	start := time.Now()
+	w := new(memoryPageWriter)
+	if err := d.Scan(context.Background(), NewScanOptions(), w); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("long-poll not used, job done in %s", elapsed)
+	}
+	if len(w.pages) != 2 {
+		t.Errorf("expected 2 pages, got %d", len(w.pages))
+	}
+}
+
+// BenchmarkTimeToFirstPage measures the delay between the start of a job and
+// the download of its first page, the simulated device needs 300ms per page.
+func BenchmarkTimeToFirstPage(b *testing.B) {
+	for _, bench := range []struct {
+		name    string
+		polling JobPolling
+		events  bool
+	}{
+		{"Fixed1s", JobPolling{Min: time.Second, Max: time.Second, NoLongPoll: true}, false},
+		{"Adaptive", JobPolling{NoLongPoll: true}, false},
+		{"LongPoll", JobPolling{}, true},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			var total time.Duration
+			for i := 0; i < b.N; i++ {
+				f := newFakeScanner(b, 1)
+				f.prepare = 300 * time.Millisecond
+				f.events = bench.events
+				d := f.device()
+				d.JobPolling = bench.polling
+
+				start := time.Now()
+				job, err := d.StartScan(context.Background(), NewScanOptions(), new(memoryPageWriter))
+				if err != nil {
+					b.Fatal(err)
+				}
+				for e := range job.Events() {
+					if e.Type == DownloadProgress && e.Lines == e.ImageHeight {
+						total += time.Since(start)
+						job.StopEvents()
+						break
+					}
+				}
+				if err = job.Wait(); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(total.Milliseconds())/float64(b.N), "ms/first-page")
+		})
+	}
+}
@@ -0,0 +1,103 @@
+// preview.go
+package hpdevices
+
+import (
+	"context"
+	"image"
+	"io"
+)
+
+// Preview is a quick scan of the whole platen, at the lowest resolution
+type Preview struct {
+	Image       image.Image
+	XResolution int
+	YResolution int
+	XScale      Length // Physical width of a pixel
+	YScale      Length // Physical height of a pixel
+}
+
+// Region maps a rectangle of the preview, in pixels, to the region to give to WithRegion
+func (p *Preview) Region(r image.Rectangle) Region {
+	r = r.Sub(p.Image.Bounds().Min)
+	return Region{
+		X:      Length(r.Min.X) * p.XScale,
+		Y:      Length(r.Min.Y) * p.YScale,
+		Width:  Length(r.Dx()) * p.XScale,
+		Height: Length(r.Dy()) * p.YScale,
+	}
+}
+
+// Preview scans the whole platen in color, or in gray when the device hasn't color,
+// at the lowest resolution the device supports.
+func (d *HPDevice) Preview(ctx context.Context) (*Preview, error) {
+	caps, err := d.ScanCapabilities(ctx)
+	if err != nil {
+		return nil, NewHPDeviceError("HPDevice.Preview", "ScanCapabilities", err)
+	}
+	platen, ok := caps.Source(SourcePlaten)
+	if !ok {
+		return nil, NewHPDeviceError("HPDevice.Preview", "No platen")
+	}
+
+	var opts ScanOptions
+	for _, ct := range []struct {
+		colorType  string
+		colorSpace string
+	}{{"Color8", "Color"}, {"Gray8", "Gray"}} {
+		entry, ok := caps.ColorEntry(ct.colorType)
+		resolutions := platen.ResolutionsFor(ct.colorType)
+		if !ok || len(resolutions) == 0 {
+			continue
+		}
+		format := "Raw"
+		if contains(entry.Formats, "Jpeg") {
+			format = "Jpeg"
+		}
+		opts = NewScanOptions(
+			WithSource(SourcePlaten),
+			WithColorSpace(ct.colorSpace),
+			WithBitDepth(8),
+			WithFormat(format),
+			WithArea(0, 0, platen.MaxWidth, platen.MaxHeight),
+			WithValidation(ValidationStrict),
+		)
+		opts.XResolution, opts.YResolution = resolutions[0].XResolution, resolutions[0].YResolution
+		break
+	}
+	if opts.XResolution == 0 {
+		return nil, NewHPDeviceError("HPDevice.Preview", "No resolution for color or gray scans on the platen")
+	}
+
+	w := new(previewWriter)
+	err = d.Scan(ctx, opts, w)
+	if err != nil {
+		return nil, err
+	}
+	if w.img == nil {
+		return nil, NewHPDeviceError("HPDevice.Preview", "No page scanned")
+	}
+	return &Preview{
+		Image:       w.img,
+		XResolution: w.info.XResolution,
+		YResolution: w.info.YResolution,
+		XScale:      FromPixels(1, w.info.XResolution),
+		YScale:      FromPixels(1, w.info.YResolution),
+	}, nil
+}
+
+// previewWriter keeps the decoded page in memory
+type previewWriter struct {
+	img  image.Image
+	info PageInfo
+}
+
+func (p *previewWriter) EncodePage(info PageInfo, img image.Image) error {
+	p.img, p.info = img, info
+	return nil
+}
+
+func (p *previewWriter) NewPage(info PageInfo) (io.WriteCloser, error) {
+	return nil, NewHPDeviceError("previewWriter.NewPage", "Pages are decoded")
+}
+
+func (p *previewWriter) EndPage(result PageResult) error { return nil }
@@ -0,0 +1,37 @@
+package hpdevices
+
+import (
+	"context"
+	"image"
+	"math"
+	"testing"
+)
+
+func Test_Preview(t *testing.T) {
+	fastPolling(t)
+	f := newFakeScanner(t, 1)
+	p, err := f.device().Preview(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss := f.settings
+	if ss.XResolution != 75 || ss.ColorSpace != "Color" || ss.InputSource != "Platen" || ss.Width != 2550 || ss.Height != 3508 {
+		t.Errorf("unexpected preview settings %+v", ss)
+	}
+	if p.Image == nil || p.Image.Bounds().Dy() != f.height || p.XResolution != 75 {
+		t.Fatalf("unexpected preview %+v", p)
+	}
+
+	// One inch from the left, two from the top, 1x2 inches
+	r := p.Region(image.Rect(75, 150, 150, 300))
+	for _, l := range []struct{ got, expected Length }{
+		{r.X, Inch}, {r.Y, 2 * Inch}, {r.Width, Inch}, {r.Height, 2 * Inch},
+	} {
+		if math.Abs(float64(l.got-l.expected)) > 1e-9 {
+			t.Errorf("unexpected region %+v", r)
+		}
+	}
+	if x, y, w, h := r.DeviceArea(); x != 300 || y != 600 || w != 300 || h != 600 {
+		t.Errorf("unexpected area %d,%d %dx%d", x, y, w, h)
+	}
+}
@@ -0,0 +1,49 @@
+// process.go
+package hpdevices
+
+import (
+	"image"
+)
+
+// Page is a decoded page going through the PageProcessors
+type Page struct {
+	Info  PageInfo
+	Image image.Image
+}
+
+// PageProcessor transforms decoded pages before they are given to the writer.
+// A processor can change the page, drop it by returning no page, or split it in
+// several pages. Processors are run in order, each on the pages given by the previous one.
+// When a single page comes out with its image untouched, the JPEG stream of the device is
+// written instead of being encoded again.
+type PageProcessor interface {
+	ProcessPage(page Page) ([]Page, error)
+}
+
+// PageProcessorFunc is a function used as a PageProcessor
+type PageProcessorFunc func(page Page) ([]Page, error)
+
+func (f PageProcessorFunc) ProcessPage(page Page) ([]Page, error) {
+	return f(page)
+}
+
+// processPage runs the processors on the page. The size of the pages is taken from their images.
+func processPage(processors []PageProcessor, page Page) ([]Page, error) {
+	pages := []Page{page}
+	for _, p := range processors {
+		var next []Page
+		for _, page := range pages {
+			processed, err := p.ProcessPage(page)
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, processed...)
+		}
+		pages = next
+	}
+	for i := range pages {
+		b := pages[i].Image.Bounds()
+		pages[i].Info.Width, pages[i].Info.Height = b.Dx(), b.Dy()
+	}
+	return pages, nil
+}
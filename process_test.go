@@ -0,0 +1,72 @@
+package hpdevices
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+func Test_Processors(t *testing.T) {
+	fastPolling(t)
+	f := newFakeScanner(t, 2)
+
+	// The first page is dropped, the second is split in two halves
+	var seen []int
+	drop := PageProcessorFunc(func(page Page) ([]Page, error) {
+		seen = append(seen, page.Info.PageNumber)
+		if page.Info.PageNumber == 1 {
+			return nil, nil
+		}
+		return []Page{page}, nil
+	})
+	split := PageProcessorFunc(func(page Page) ([]Page, error) {
+		img := page.Image.(*image.Gray)
+		b := img.Bounds()
+		middle := b.Min.Y + b.Dy()/2
+		top := img.SubImage(image.Rect(b.Min.X, b.Min.Y, b.Max.X, middle))
+		bottom := img.SubImage(image.Rect(b.Min.X, middle, b.Max.X, b.Max.Y))
+		return []Page{{page.Info, top}, {page.Info, bottom}}, nil
+	})
+
+	w := new(memoryPageWriter)
+	if err := f.device().Scan(context.Background(), NewScanOptions(WithProcessors(drop, split)), w); err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 2 || len(w.pages) != 2 {
+		t.Fatalf("expected 2 halves of page 2, got %d pages", len(w.pages))
+	}
+	for i, info := range w.infos {
+		if info.PageNumber != 2 || info.Height != f.height/2 || info.Width != 30 {
+			t.Errorf("half %d: unexpected information %+v", i, info)
+		}
+	}
+	// Dropped pages have no result
+	if len(w.results) != 1 || w.results[0].PageNumber != 2 {
+		t.Errorf("unexpected results %+v", w.results)
+	}
+}
+
+func Test_AutoRotate(t *testing.T) {
+	fastPolling(t)
+	f := newFakeScanner(t, 1)
+	page := textPage()
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, rotateImage(page, 90), nil); err != nil {
+		t.Fatal(err)
+	}
+	f.pages[0] = b.Bytes()
+	f.height = page.Rect.Dx()
+
+	// The device tells the page is upside down, the text tells it's on its side
+	f.orientations = map[int]string{1: "Flipped"}
+	w := new(memoryPageWriter)
+	if err := f.device().Scan(context.Background(), NewScanOptions(WithProcessors(AutoRotate{})), w); err != nil {
+		t.Fatal(err)
+	}
+	info := w.infos[0]
+	if info.Rotation != 270 || info.Width != page.Rect.Dx() || info.Height != page.Rect.Dy() {
+		t.Errorf("page not turned upright: %+v", info)
+	}
+}
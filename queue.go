@@ -0,0 +1,143 @@
+// queue.go
+package hpdevices
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrScannerBusy is returned when the scanner stays busy with another job, like
+// a scan started from the panel or another computer, longer than the BusyTimeout.
+var ErrScannerBusy = errors.New("Scanner busy")
+
+// ErrScannerError is returned when the scanner reports an error state, like a paper jam
+var ErrScannerError = errors.New("Scanner error")
+
+// DefaultBusyTimeout is used by devices having no BusyTimeout
+var DefaultBusyTimeout = 2 * time.Minute
+
+// jobQueues are the queues of the scanners, by address. Devices built for the same
+// scanner, from the registry and from discovery for instance, share the queue.
+var jobQueues = struct {
+	sync.Mutex
+	queues map[string]*jobQueue
+}{queues: make(map[string]*jobQueue)}
+
+// queue gives the job queue of the device scanner
+func (d *HPDevice) queue() *jobQueue {
+	key := d.IPAddress
+	if u, err := url.Parse(d.URL); err == nil && u.Host != "" {
+		key = u.Host
+	}
+	key = strings.ToLower(key)
+	jobQueues.Lock()
+	defer jobQueues.Unlock()
+	q, ok := jobQueues.queues[key]
+	if !ok {
+		q = new(jobQueue)
+		jobQueues.queues[key] = q
+	}
+	return q
+}
+
+// jobQueue runs the scan jobs of a scanner one at a time, in order
+type jobQueue struct {
+	mutex sync.Mutex
+	jobs  []*queuedJob // The running job first
+}
+
+type queuedJob struct {
+	turn     chan struct{} // Closed when the job is first
+	position func(int)     // Receives the number of jobs before this one, may be nil
+}
+
+// enter waits for the jobs queued before. The job leaves the queue when ctx is done,
+// otherwise leave must be called at the end of the job.
+func (q *jobQueue) enter(ctx context.Context, position func(int)) (leave func(), err error) {
+	j := &queuedJob{turn: make(chan struct{}), position: position}
+	q.mutex.Lock()
+	q.jobs = append(q.jobs, j)
+	if len(q.jobs) == 1 {
+		close(j.turn)
+	} else {
+		j.report(len(q.jobs) - 1)
+	}
+	q.mutex.Unlock()
+
+	select {
+	case <-j.turn:
+		return func() { q.remove(j) }, nil
+	case <-ctx.Done():
+		q.remove(j)
+		return nil, ctx.Err()
+	}
+}
+
+// remove takes the job out of the queue, and moves up the following ones
+func (q *jobQueue) remove(j *queuedJob) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for i := range q.jobs {
+		if q.jobs[i] != j {
+			continue
+		}
+		q.jobs = append(q.jobs[:i], q.jobs[i+1:]...)
+		if i == 0 && len(q.jobs) > 0 {
+			close(q.jobs[0].turn)
+		}
+		for k := i; k < len(q.jobs); k++ {
+			q.jobs[k].report(k)
+		}
+		return
+	}
+}
+
+func (j *queuedJob) report(position int) {
+	if j.position != nil {
+		j.position(position)
+	}
+}
+
+// acquire waits for the jobs queued before on the device, then for the scanner to be idle.
+// release must be called at the end of the job.
+func (sj *hpscanJob) acquire(ctx context.Context) (release func(), err error) {
+	d := sj.Device
+	var position func(int)
+	if sj.events != nil {
+		position = func(n int) {
+			sj.events(ScanEvent{Type: JobQueued, JobState: JobPending, QueuePosition: n})
+		}
+	}
+	release, err = d.queue().enter(ctx, position)
+	if err != nil {
+		return nil, NewHPDeviceError("HPDevice.ScanJob", "Queued", ErrScanCanceled)
+	}
+
+	timeout := d.BusyTimeout
+	if timeout <= 0 {
+		timeout = DefaultBusyTimeout
+	}
+	idleCtx, cancel := context.WithTimeout(ctx, timeout)
+	err = sj.waitIdle(idleCtx)
+	cancel()
+	switch {
+	case err == nil:
+	case ctx.Err() != nil:
+		release()
+		return nil, NewHPDeviceError("HPDevice.ScanJob", "Wait idle scanner", ErrScanCanceled)
+	case errors.Is(err, ErrScannerError):
+		release()
+		return nil, NewHPDeviceError("HPDevice.ScanJob", "Wait idle scanner", err)
+	case idleCtx.Err() != nil:
+		release()
+		return nil, NewHPDeviceError("HPDevice.ScanJob", "Wait idle scanner", ErrScannerBusy)
+	default:
+		// The job POST tells if the scanner is really busy
+		TRACE.Println("HPDevice.ScanJob: scanner state unknown", err)
+	}
+	return release, nil
+}
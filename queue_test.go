@@ -0,0 +1,126 @@
+package hpdevices
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_JobQueue(t *testing.T) {
+	q := new(jobQueue)
+	positions := map[string]chan int{"b": make(chan int, 10), "c": make(chan int, 10)}
+	report := func(name string) func(int) { return func(n int) { positions[name] <- n } }
+	expect := func(name string, position int) {
+		t.Helper()
+		select {
+		case n := <-positions[name]:
+			if n != position {
+				t.Errorf("%s: position %d, expected %d", name, n, position)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: position %d not reported", name, position)
+		}
+	}
+
+	leaveA, err := q.enter(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctxB, cancelB := context.WithCancel(context.Background())
+	errB := make(chan error)
+	go func() {
+		_, err := q.enter(ctxB, report("b"))
+		errB <- err
+	}()
+	expect("b", 1)
+	leaveC := make(chan func())
+	go func() {
+		leave, _ := q.enter(context.Background(), report("c"))
+		leaveC <- leave
+	}()
+	expect("c", 2)
+
+	// b is canceled while waiting, c moves up
+	cancelB()
+	if err = <-errB; err != context.Canceled {
+		t.Errorf("b: got %v", err)
+	}
+	expect("c", 1)
+
+	leaveA()
+	expect("c", 0)
+	(<-leaveC)()
+	if len(q.jobs) != 0 {
+		t.Errorf("%d jobs left in the queue", len(q.jobs))
+	}
+}
+
+func Test_QueuedJobs(t *testing.T) {
+	fastPolling(t)
+	f := newFakeScanner(t, 1)
+	f.stall, f.stalled = make(chan struct{}), make(chan struct{})
+	d := f.device()
+
+	first, err := d.StartScan(context.Background(), NewScanOptions(), new(memoryPageWriter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-f.stalled
+	// Another instance of the same scanner, like one from the registry
+	second, err := f.device().StartScan(context.Background(), NewScanOptions(), new(memoryPageWriter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := <-second.Events()
+	if e.Type != JobQueued || e.QueuePosition != 1 || second.QueuePosition() != 1 || second.State() != JobPending {
+		t.Fatalf("unexpected event %+v", e)
+	}
+
+	// The queued job is canceled without touching the running one
+	second.Cancel()
+	if err = second.Wait(); !errors.Is(err, ErrScanCanceled) || second.State() != JobCanceled {
+		t.Fatalf("queued job not canceled: %v", err)
+	}
+	close(f.stall)
+	if err = first.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if f.posted != 1 || f.canceled {
+		t.Errorf("posted %d, canceled %v", f.posted, f.canceled)
+	}
+}
+
+func Test_BusyScanner(t *testing.T) {
+	fastPolling(t)
+	f := newFakeScanner(t, 1)
+	f.busy = 3
+	if err := f.device().Scan(context.Background(), NewScanOptions(), new(memoryPageWriter)); err != nil {
+		t.Fatal(err)
+	}
+	if f.busy != 0 || f.posted != 1 {
+		t.Errorf("job posted while busy: busy %d, posted %d", f.busy, f.posted)
+	}
+
+	f = newFakeScanner(t, 1)
+	f.busy = 1 << 30
+	d := f.device()
+	d.BusyTimeout = 20 * time.Millisecond
+	if err := d.Scan(context.Background(), NewScanOptions(), new(memoryPageWriter)); !errors.Is(err, ErrScannerBusy) {
+		t.Fatalf("expected ErrScannerBusy, got %v", err)
+	}
+	if f.posted != 0 {
+		t.Error("job posted to a busy scanner")
+	}
+
+	// A jammed scanner isn't waited for
+	f = newFakeScanner(t, 1)
+	f.state = "JamError"
+	start := time.Now()
+	if err := f.device().Scan(context.Background(), NewScanOptions(), new(memoryPageWriter)); !errors.Is(err, ErrScannerError) {
+		t.Fatalf("expected ErrScannerError, got %v", err)
+	}
+	if f.posted != 0 || time.Since(start) > 10*time.Second {
+		t.Errorf("jammed scanner waited for, posted %d", f.posted)
+	}
+}
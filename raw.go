@@ -0,0 +1,103 @@
+// raw.go
+package hpdevices
+
+import (
+	"image"
+	"image/color"
+	"io"
+	"strconv"
+)
+
+// RawImage describes the pixels of a page scanned in Raw format. Lines are
+// BytesPerLine long, padding included.
+type RawImage struct {
+	Width        int
+	Height       int
+	BytesPerLine int
+	ColorSpace   string // Gray or Color
+	BitDepth     int    // 1 or 8
+}
+
+// bilevelPalette is used for 1 bit scans, where set bits are black
+var bilevelPalette = color.Palette{color.Gray{0xff}, color.Gray{0}}
+
+// lineLength gives the number of useful bytes of a line
+func (ri RawImage) lineLength() int {
+	switch colorType(ri.ColorSpace, ri.BitDepth) {
+	case "K1":
+		return (ri.Width + 7) / 8
+	case "Gray8":
+		return ri.Width
+	case "Color8":
+		return ri.Width * 3
+	}
+	return 0
+}
+
+// DecodeRaw reads a Raw page into an image.Gray, an image.RGBA, or an image.Paletted
+// for 1 bit scans. A page shorter than Height, as given by the ADF, is cropped to the lines read.
+func DecodeRaw(r io.Reader, ri RawImage) (image.Image, error) {
+	length := ri.lineLength()
+	if length == 0 {
+		return nil, NewHPDeviceError("DecodeRaw", "Unsupported color type "+colorType(ri.ColorSpace, ri.BitDepth))
+	}
+	if ri.BytesPerLine == 0 {
+		ri.BytesPerLine = length
+	}
+	if ri.Width <= 0 || ri.Height <= 0 || ri.BytesPerLine < length {
+		return nil, NewHPDeviceError("DecodeRaw", "Invalid size "+strconv.Itoa(ri.Width)+"x"+strconv.Itoa(ri.Height)+
+			", "+strconv.Itoa(ri.BytesPerLine)+" bytes per line")
+	}
+
+	bounds := image.Rect(0, 0, ri.Width, ri.Height)
+	var (
+		img     image.Image
+		setLine func(y int, line []byte)
+	)
+	switch colorType(ri.ColorSpace, ri.BitDepth) {
+	case "K1":
+		p := image.NewPaletted(bounds, bilevelPalette)
+		setLine = func(y int, line []byte) {
+			row := p.Pix[y*p.Stride:]
+			for x := 0; x < ri.Width; x++ {
+				row[x] = line[x/8] >> uint(7-x%8) & 1
+			}
+		}
+		img = p
+	case "Gray8":
+		g := image.NewGray(bounds)
+		setLine = func(y int, line []byte) {
+			copy(g.Pix[y*g.Stride:], line[:ri.Width])
+		}
+		img = g
+	case "Color8":
+		c := image.NewRGBA(bounds)
+		setLine = func(y int, line []byte) {
+			row := c.Pix[y*c.Stride:]
+			for x := 0; x < ri.Width; x++ {
+				row[4*x], row[4*x+1], row[4*x+2], row[4*x+3] = line[3*x], line[3*x+1], line[3*x+2], 0xff
+			}
+		}
+		img = c
+	}
+
+	line := make([]byte, ri.BytesPerLine)
+	y := 0
+	for ; y < ri.Height; y++ {
+		_, err := io.ReadFull(r, line)
+		if err == io.EOF && y > 0 {
+			break
+		}
+		if err != nil {
+			return nil, NewHPDeviceError("DecodeRaw", "Line "+strconv.Itoa(y), err)
+		}
+		setLine(y, line)
+	}
+	if y < ri.Height {
+		TRACE.Println("DecodeRaw: page cropped to", y, "lines instead of", ri.Height)
+		img = img.(interface {
+			SubImage(image.Rectangle) image.Image
+		}).SubImage(image.Rect(0, 0, ri.Width, y))
+	}
+	return img, nil
+}
@@ -0,0 +1,131 @@
+package hpdevices
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"golang.org/x/image/tiff"
+)
+
+func Test_DecodeRaw(t *testing.T) {
+	// 10 pixels on 2 bytes, padded to 3 bytes per line
+	k1 := []byte{0xA0, 0x40, 0, 0xFF, 0xC0, 0}
+	img, err := DecodeRaw(bytes.NewReader(k1), RawImage{Width: 10, Height: 2, BytesPerLine: 3, ColorSpace: "Gray", BitDepth: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, ok := img.(*image.Paletted)
+	if !ok {
+		t.Fatalf("expected a paletted image, got %T", img)
+	}
+	if p.ColorIndexAt(0, 0) != 1 || p.ColorIndexAt(1, 0) != 0 || p.ColorIndexAt(2, 0) != 1 || p.ColorIndexAt(9, 0) != 1 || p.ColorIndexAt(9, 1) != 1 {
+		t.Errorf("unexpected pixels %v", p.Pix)
+	}
+	if p.At(0, 0) != (color.Gray{0}) {
+		t.Errorf("set bits must be black")
+	}
+
+	gray := []byte{1, 2, 3, 0, 4, 5, 6, 0}
+	img, err = DecodeRaw(bytes.NewReader(gray), RawImage{Width: 3, Height: 2, BytesPerLine: 4, ColorSpace: "Gray", BitDepth: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g := img.(*image.Gray); !bytes.Equal(g.Pix, []byte{1, 2, 3, 4, 5, 6}) {
+		t.Errorf("unexpected pixels %v", g.Pix)
+	}
+
+	rgb := []byte{10, 20, 30, 40, 50, 60}
+	img, err = DecodeRaw(bytes.NewReader(rgb), RawImage{Width: 2, Height: 1, ColorSpace: "Color", BitDepth: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := img.(*image.RGBA); !bytes.Equal(c.Pix, []byte{10, 20, 30, 255, 40, 50, 60, 255}) {
+		t.Errorf("unexpected pixels %v", c.Pix)
+	}
+
+	// The ADF gives a shorter page than announced
+	img, err = DecodeRaw(bytes.NewReader(gray), RawImage{Width: 4, Height: 10, ColorSpace: "Gray", BitDepth: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds() != image.Rect(0, 0, 4, 2) {
+		t.Errorf("page not cropped, %v", img.Bounds())
+	}
+
+	for _, ri := range []RawImage{
+		{Width: 3, Height: 2, ColorSpace: "Color", BitDepth: 16},
+		{Width: 3, Height: 2, BytesPerLine: 2, ColorSpace: "Gray", BitDepth: 8},
+		{Width: 0, Height: 2, ColorSpace: "Gray", BitDepth: 8},
+	} {
+		if _, err = DecodeRaw(bytes.NewReader(gray), ri); err == nil {
+			t.Errorf("expected an error for %+v", ri)
+		}
+	}
+	if _, err = DecodeRaw(bytes.NewReader(nil), RawImage{Width: 3, Height: 2, ColorSpace: "Gray", BitDepth: 8}); err == nil {
+		t.Error("expected an error for an empty page")
+	}
+}
+
+// rawFakeScanner serves Gray8 pages of 30x40 pixels in Raw format
+func rawFakeScanner(t *testing.T, pages int) *fakeScanner {
+	f := newFakeScanner(t, 0)
+	for i := 0; i < pages; i++ {
+		f.pages = append(f.pages, bytes.Repeat([]byte{byte(i + 1)}, 30*f.height))
+	}
+	return f
+}
+
+func Test_ScanRaw(t *testing.T) {
+	fastPolling(t)
+	opts := NewScanOptions(WithFormat("Raw"))
+
+	// Raw pages are given in PNG to plain writers
+	f := rawFakeScanner(t, 1)
+	w := new(memoryPageWriter)
+	if err := f.device().Scan(context.Background(), opts, w); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(w.pages[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != 30 || img.Bounds().Dy() != f.height || color.GrayModel.Convert(img.At(5, 5)) != (color.Gray{1}) {
+		t.Errorf("unexpected image %v %v", img.Bounds(), img.At(5, 5))
+	}
+
+	f = rawFakeScanner(t, 2)
+	w = new(memoryPageWriter)
+	if err = f.device().Scan(context.Background(), opts, TIFFWriter{PageWriter: w}); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.pages) != 2 {
+		t.Fatalf("expected 2 pages, got %d", len(w.pages))
+	}
+	img, err = tiff.Decode(w.pages[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if color.GrayModel.Convert(img.At(0, 0)) != (color.Gray{2}) {
+		t.Errorf("unexpected pixel %v", img.At(0, 0))
+	}
+}
+
+func Test_PNGWriterJpeg(t *testing.T) {
+	fastPolling(t)
+	f := newFakeScanner(t, 1)
+	w := new(memoryPageWriter)
+	if err := f.device().Scan(context.Background(), NewScanOptions(), PNGWriter{w}); err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(w.pages[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dy() != f.height {
+		t.Errorf("unexpected size %v", img.Bounds())
+	}
+}
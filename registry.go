@@ -0,0 +1,271 @@
+// registry.go
+package hpdevices
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RegistryEntry is what the registry remembers about a device
+type RegistryEntry struct {
+	UUID         string
+	ModelName    string
+	SerialNumber string
+	HostName     string
+	IPAddress    string
+	URL          string
+	Capabilities []string // Resource types advertised in the device discovery tree
+	LastSeen     time.Time
+}
+
+// Device gives a device built from the entry
+func (e *RegistryEntry) Device() *HPDevice {
+	return &HPDevice{
+		URL:          e.URL,
+		IPAddress:    e.IPAddress,
+		HostName:     e.HostName,
+		ModelName:    e.ModelName,
+		SerialNumber: e.SerialNumber,
+		UUID:         e.UUID,
+	}
+}
+
+// Registry is a JSON file remembering known devices. Entries are keyed by
+// UUID, so they survive a change of address.
+type Registry struct {
+	Path    string
+	mutex   sync.Mutex
+	entries map[string]*RegistryEntry // nil until the file is loaded
+}
+
+// DefaultRegistry is used by LocalizeDevice and NewHPDevice functions. Set it to nil to disable the cache.
+var DefaultRegistry = NewRegistry(defaultRegistryPath())
+
+func defaultRegistryPath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "hpdevices", "devices.json")
+}
+
+// NewRegistry creates a registry stored at path. The file is read at first use.
+func NewRegistry(path string) *Registry {
+	return &Registry{Path: path}
+}
+
+// load reads the file once, a missing file is an empty registry. Must be called with the mutex held.
+func (r *Registry) load() (err error) {
+	if r.entries != nil {
+		return nil
+	}
+	r.entries = make(map[string]*RegistryEntry)
+	buffer, err := ioutil.ReadFile(r.Path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return NewHPDeviceError("Registry.load", "ReadFile", err)
+	}
+	err = json.Unmarshal(buffer, &r.entries)
+	if err != nil {
+		return NewHPDeviceError("Registry.load", "Unmarshal", err)
+	}
+	return nil
+}
+
+// save writes the whole registry in a temporary file, renamed when complete. Must be called with the mutex held.
+func (r *Registry) save() (err error) {
+	buffer, err := json.MarshalIndent(r.entries, "", "\t")
+	if err != nil {
+		return NewHPDeviceError("Registry.save", "Marshal", err)
+	}
+	err = os.MkdirAll(filepath.Dir(r.Path), 0755)
+	if err != nil {
+		return NewHPDeviceError("Registry.save", "MkdirAll", err)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(r.Path), filepath.Base(r.Path))
+	if err != nil {
+		return NewHPDeviceError("Registry.save", "TempFile", err)
+	}
+	_, err = tmp.Write(buffer)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), r.Path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return NewHPDeviceError("Registry.save", "Write", err)
+	}
+	return nil
+}
+
+// Lookup returns the entry of the device with the given UUID
+func (r *Registry) Lookup(uuid string) (entry RegistryEntry, ok bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.load() != nil {
+		return entry, false
+	}
+	e, ok := r.entries[uuid]
+	if ok {
+		entry = *e
+	}
+	return entry, ok
+}
+
+// LookupURL returns the entry of the device last seen at the given URL
+func (r *Registry) LookupURL(url string) (entry RegistryEntry, ok bool) {
+	for _, e := range r.Entries() {
+		if e.URL == url {
+			return e, true
+		}
+	}
+	return entry, false
+}
+
+// Entries returns all entries, most recently seen first
+func (r *Registry) Entries() []RegistryEntry {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.load() != nil {
+		return nil
+	}
+	entries := make([]RegistryEntry, 0, len(r.entries))
+	for _, e := range r.entries {
+		entries = append(entries, *e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].LastSeen.After(entries[j].LastSeen) })
+	return entries
+}
+
+// Update records devices seen now, and saves the registry.
+// Devices without UUID can't be recorded.
+func (r *Registry) Update(devices ...*HPDevice) error {
+	// Asked before locking the registry, devices may be slow to answer
+	capabilities := make([][]string, len(devices))
+	for i, d := range devices {
+		if d.UUID != "" {
+			capabilities[i], _ = d.resourceTypes()
+		}
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if err := r.load(); err != nil {
+		return err
+	}
+	updated := false
+	for i, d := range devices {
+		if d.UUID == "" {
+			continue
+		}
+		e, ok := r.entries[d.UUID]
+		if !ok {
+			e = &RegistryEntry{UUID: d.UUID}
+			r.entries[d.UUID] = e
+		}
+		e.ModelName, e.SerialNumber, e.HostName = d.ModelName, d.SerialNumber, d.HostName
+		e.IPAddress, e.URL = d.IPAddress, d.URL
+		if capabilities[i] != nil {
+			e.Capabilities = capabilities[i]
+		}
+		e.LastSeen = time.Now()
+		updated = true
+	}
+	if !updated {
+		return nil
+	}
+	return r.save()
+}
+
+// Remove forgets the device with the given UUID
+func (r *Registry) Remove(uuid string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if err := r.load(); err != nil {
+		return err
+	}
+	if _, ok := r.entries[uuid]; !ok {
+		return nil
+	}
+	delete(r.entries, uuid)
+	return r.save()
+}
+
+// resourceTypes lists the resource types advertised by the device
+func (d *HPDevice) resourceTypes() (types []string, err error) {
+	resources, err := d.Discover(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range resources.Resources {
+		types = append(types, r.Type)
+	}
+	return types, nil
+}
+
+// refreshes are the registry updates running in background
+var refreshes sync.WaitGroup
+
+// RegistryRefreshInterval is the time between two background discoveries of the registry
+var RegistryRefreshInterval = time.Minute
+
+// registryRefresh lets a single background discovery run at once
+var registryRefresh struct {
+	sync.Mutex
+	running bool
+	last    time.Time // End of the last discovery
+}
+
+// refreshRegistry discovers devices in background and records them, unless
+// a discovery is running or has been done within RegistryRefreshInterval
+func refreshRegistry() {
+	if DefaultRegistry == nil {
+		return
+	}
+	registryRefresh.Lock()
+	defer registryRefresh.Unlock()
+	if registryRefresh.running || time.Since(registryRefresh.last) < RegistryRefreshInterval {
+		return
+	}
+	registryRefresh.running = true
+	refreshes.Add(1)
+	go func() {
+		defer refreshes.Done()
+		if _, err := DiscoverDevices(); err != nil {
+			TRACE.Println("refreshRegistry", err)
+		}
+		registryRefresh.Lock()
+		registryRefresh.running, registryRefresh.last = false, time.Now()
+		registryRefresh.Unlock()
+	}()
+}
+
+// refreshDevice records the device in background, with its identity
+func refreshDevice(d *HPDevice) {
+	registry := DefaultRegistry
+	if registry == nil {
+		return
+	}
+	device := &HPDevice{URL: d.URL, IPAddress: d.IPAddress, HostName: d.HostName, Client: d.Client}
+	refreshes.Add(1)
+	go func() {
+		defer refreshes.Done()
+		if err := device.Identify(); err != nil {
+			TRACE.Println("refreshDevice", err)
+			return
+		}
+		if err := registry.Update(device); err != nil {
+			TRACE.Println("refreshDevice", err)
+		}
+	}()
+}
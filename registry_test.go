@@ -0,0 +1,162 @@
+package hpdevices
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newLEDMServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/DevMgmt/DiscoveryTree.xml":
+			fmt.Fprint(w, discoveryTreeXML)
+		case "/DevMgmt/ProductConfigDyn.xml":
+			fmt.Fprint(w, productConfigDynXML)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func Test_Registry(t *testing.T) {
+	server := newLEDMServer()
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "devices.json")
+
+	r := NewRegistry(path)
+	d := &HPDevice{URL: server.URL, IPAddress: "127.0.0.1", UUID: "1c85", ModelName: "HP Officejet Pro 8600"}
+	if err = r.Update(d, &HPDevice{URL: "http://10.0.0.1:8080"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Read back by a new process
+	r = NewRegistry(path)
+	e, ok := r.Lookup("1c85")
+	if !ok || e.URL != server.URL || e.ModelName != "HP Officejet Pro 8600" || e.LastSeen.IsZero() {
+		t.Fatalf("unexpected entry %+v", e)
+	}
+	if len(e.Capabilities) != 2 || e.Capabilities[0] != "ledm:hpLedmProductConfigDyn" {
+		t.Errorf("unexpected capabilities %v", e.Capabilities)
+	}
+	if len(r.Entries()) != 1 {
+		t.Errorf("device without UUID recorded")
+	}
+
+	// New address, same UUID
+	d.URL, d.IPAddress = "http://10.0.0.2:8080", "10.0.0.2"
+	r.Update(d)
+	if e, ok = r.LookupURL("http://10.0.0.2:8080"); !ok || e.UUID != "1c85" {
+		t.Errorf("address change not recorded %+v", e)
+	}
+	if err = r.Remove("1c85"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok = NewRegistry(path).Lookup("1c85"); ok {
+		t.Error("removed entry still there")
+	}
+}
+
+func Test_NewHPDeviceFromRegistry(t *testing.T) {
+	server := newLEDMServer()
+	defer server.Close()
+	DefaultRegistry.Update(&HPDevice{URL: server.URL, UUID: "cached", ModelName: "Cached model"})
+	defer DefaultRegistry.Remove("cached")
+
+	d, err := NewHPDevice(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.UUID != "cached" || d.ModelName != "Cached model" {
+		t.Errorf("identity not taken from registry %+v", d)
+	}
+	d, err = LocalizeDeviceByUUID("cached")
+	if err != nil || d.URL != server.URL {
+		t.Errorf("LocalizeDeviceByUUID got %v, %v", d, err)
+	}
+}
+
+func Test_CachedDeviceStaleEntries(t *testing.T) {
+	server := newLEDMServer()
+	defer server.Close()
+	release := make(chan struct{})
+	stale := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-release }))
+	defer stale.Close()
+	defer close(release)
+
+	refreshes.Wait() // Of the previous tests, using the registry
+	defer func(registry *Registry, timeout time.Duration) {
+		DefaultRegistry, EndpointTimeout = registry, timeout
+	}(DefaultRegistry, EndpointTimeout)
+	EndpointTimeout = 300 * time.Millisecond
+	// Entries seen more recently than the answering device don't answer anymore
+	entries := map[string]*RegistryEntry{"answering": {UUID: "answering", ModelName: "HP Officejet", URL: server.URL, LastSeen: time.Now().Add(-time.Hour)}}
+	for i := 0; i < 5; i++ {
+		uuid := fmt.Sprint("stale", i)
+		entries[uuid] = &RegistryEntry{UUID: uuid, ModelName: "HP Officejet", URL: stale.URL, LastSeen: time.Now()}
+	}
+	DefaultRegistry = &Registry{entries: entries}
+
+	start := time.Now()
+	d := cachedDevice(ByModel("officejet"))
+	if d == nil || d.UUID != "answering" {
+		t.Fatalf("got %v", d)
+	}
+	if elapsed := time.Since(start); elapsed > 3*EndpointTimeout {
+		t.Errorf("stale entries probed one at a time, %v", elapsed)
+	}
+}
+
+// blockingDiscoverer counts its probes, which last until release is closed
+type blockingDiscoverer struct {
+	probes  *int32
+	release chan struct{}
+}
+
+func (b blockingDiscoverer) Probe(time.Duration) ([]*HPDevice, error) {
+	atomic.AddInt32(b.probes, 1)
+	<-b.release
+	return nil, nil
+}
+
+func Test_RefreshRegistryOnce(t *testing.T) {
+	refreshes.Wait()
+	var probes int32
+	discoverer := blockingDiscoverer{&probes, make(chan struct{})}
+	defer func(d Discoverer, interval time.Duration) {
+		DefaultDiscoverer, RegistryRefreshInterval = d, interval
+	}(DefaultDiscoverer, RegistryRefreshInterval)
+	DefaultDiscoverer, RegistryRefreshInterval = discoverer, time.Hour
+	registryRefresh.Lock()
+	registryRefresh.last = time.Time{}
+	registryRefresh.Unlock()
+
+	for i := 0; i < 5; i++ {
+		refreshRegistry()
+	}
+	close(discoverer.release)
+	refreshes.Wait()
+	refreshRegistry() // Done recently
+	refreshes.Wait()
+	if n := atomic.LoadInt32(&probes); n != 1 {
+		t.Errorf("expected a single discovery, got %d", n)
+	}
+
+	RegistryRefreshInterval = 0
+	refreshRegistry()
+	refreshes.Wait()
+	if n := atomic.LoadInt32(&probes); n != 2 {
+		t.Errorf("registry not refreshed after the interval, got %d discoveries", n)
+	}
+}
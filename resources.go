@@ -0,0 +1,205 @@
+// resources.go
+package hpdevices
+
+import (
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Resource is a resource advertised by the device
+type Resource struct {
+	Type      string // Like ledm:hpLedmProductConfigDyn, or ScanCaps
+	URI       string // Absolute path on the device
+	Revision  string
+	Manifest  string // URI of the manifest listing the resource, empty for the discovery tree
+	Interface string // Type of the interface of the manifest, like ledm:hpCnxScanManifest
+}
+
+// DeviceResources are the resources found in the discovery tree and its manifests
+type DeviceResources struct {
+	Revision  string // Revision of the discovery tree
+	Resources []Resource
+}
+
+// Lookup returns the URI of the first resource having one of the given types.
+// Types are compared without their namespace prefix.
+func (r *DeviceResources) Lookup(types ...string) (uri string, ok bool) {
+	for _, t := range types {
+		t = localResourceType(t)
+		for _, resource := range r.Resources {
+			if localResourceType(resource.Type) == t {
+				return resource.URI, true
+			}
+		}
+	}
+	return "", false
+}
+
+// LookupIn is like Lookup, among the resources listed by the manifest of the given interface type
+func (r *DeviceResources) LookupIn(ifc string, types ...string) (uri string, ok bool) {
+	ifc = localResourceType(ifc)
+	for _, t := range types {
+		t = localResourceType(t)
+		for _, resource := range r.Resources {
+			if localResourceType(resource.Interface) == ifc && localResourceType(resource.Type) == t {
+				return resource.URI, true
+			}
+		}
+	}
+	return "", false
+}
+
+func localResourceType(t string) string {
+	return t[strings.LastIndex(t, ":")+1:]
+}
+
+// knownResource is a resource used by the package, with the path used when the
+// device doesn't advertise it. Resources of an interface are looked for in its manifest only.
+type knownResource struct {
+	path  string
+	types []string
+	ifc   string
+}
+
+// scanManifest is the interface of the scan resources
+const scanManifest = "hpCnxScanManifest"
+
+var (
+	productConfigResource      = knownResource{"/DevMgmt/ProductConfigDyn.xml", []string{"hpLedmProductConfigDyn", "ProductConfigDyn"}, ""}
+	scanCapsResource           = knownResource{"/Scan/ScanCaps", []string{"ScanCaps"}, scanManifest}
+	scanStatusResource         = knownResource{"/Scan/Status", []string{"ScanStatus"}, scanManifest}
+	scanJobsResource           = knownResource{"/Scan/Jobs", []string{"ScanJobs"}, scanManifest}
+	eventTableResource         = knownResource{"/EventMgmt/EventTable", []string{"EventTable"}, ""}
+	walkupDestinationsResource = knownResource{"/WalkupScanToComp/WalkupScanToCompDestinations", []string{"WalkupScanToCompDestinations"}, ""}
+	walkupEventResource        = knownResource{"/WalkupScanToComp/WalkupScanToCompEvent", []string{"WalkupScanToCompEvent"}, ""}
+)
+
+// DiscoverRetry is the time before a failed discovery is tried again by the package.
+// Meanwhile, the well known paths are used.
+var DiscoverRetry = time.Minute
+
+// resourceCache keeps the resources discovered on a device, it's shared by the copies of the device
+type resourceCache struct {
+	mutex     sync.Mutex
+	resources *DeviceResources // nil until discovered
+	failed    time.Time        // Time of the last failed discovery
+}
+
+// resourceCacheMutex guards the creation of the device caches
+var resourceCacheMutex sync.Mutex
+
+func (d *HPDevice) cache() *resourceCache {
+	resourceCacheMutex.Lock()
+	defer resourceCacheMutex.Unlock()
+	if d.resources == nil {
+		d.resources = new(resourceCache)
+	}
+	return d.resources
+}
+
+// Discover reads the discovery tree of the device and the manifests it
+// refers to. The result is kept to resolve the endpoints used by the package.
+// A failed discovery is tried again after DiscoverRetry.
+func (d *HPDevice) Discover(ctx context.Context) (resources *DeviceResources, err error) {
+	tree := new(discoveryTree)
+	err = d.getXML(ctx, d.URL+"/DevMgmt/DiscoveryTree.xml", tree)
+	if err != nil {
+		if ctx.Err() == nil {
+			c := d.cache()
+			c.mutex.Lock()
+			c.failed = time.Now()
+			c.mutex.Unlock()
+		}
+		return nil, NewHPDeviceError("HPDevice.Discover", "DiscoveryTree", err)
+	}
+
+	resources = &DeviceResources{Revision: tree.Revision}
+	for _, t := range tree.SupportedTrees {
+		resources.Resources = append(resources.Resources, Resource{Type: t.ResourceType, URI: t.ResourceURI, Revision: t.Revision})
+	}
+	for _, ifc := range tree.SupportedIfcs {
+		resources.Resources = append(resources.Resources, Resource{Type: ifc.ResourceType, URI: ifc.ManifestURI, Revision: ifc.Revision})
+		m := new(manifest)
+		err = d.getXML(ctx, d.resolve(ifc.ManifestURI), m)
+		if err != nil {
+			// An unreadable manifest hides only its own resources
+			TRACE.Println("HPDevice.Discover", "Manifest", ifc.ManifestURI, err)
+			continue
+		}
+		for _, resourceMap := range m.ResourceMaps {
+			for _, r := range resourceMap.Resources {
+				resources.Resources = append(resources.Resources, Resource{
+					Type:      r.ResourceType.value(),
+					URI:       joinResourceURI(resourceMap.ResourceLink, r.ResourceURI),
+					Manifest:  ifc.ManifestURI,
+					Interface: ifc.ResourceType,
+				})
+			}
+		}
+	}
+
+	c := d.cache()
+	c.mutex.Lock()
+	c.resources = resources
+	c.mutex.Unlock()
+	return resources, nil
+}
+
+// endpoint gives the URL of a resource, as advertised by the device
+func (d *HPDevice) endpoint(ctx context.Context, r knownResource) string {
+	c := d.cache()
+	c.mutex.Lock()
+	resources, failed := c.resources, c.failed
+	c.mutex.Unlock()
+	if resources == nil && time.Since(failed) >= DiscoverRetry {
+		// On failure, the well known paths are used until the next try
+		resources, _ = d.Discover(ctx)
+	}
+	if resources != nil {
+		lookup := resources.Lookup
+		if r.ifc != "" {
+			lookup = func(types ...string) (string, bool) { return resources.LookupIn(r.ifc, types...) }
+		}
+		if uri, ok := lookup(r.types...); ok {
+			return d.resolve(uri)
+		}
+	}
+	return d.URL + r.path
+}
+
+// joinResourceURI places a manifest resource under the manifest resource link
+func joinResourceURI(link, uri string) string {
+	if strings.Contains(uri, "://") || link == "" {
+		return uri
+	}
+	if !strings.HasPrefix(uri, "/") {
+		uri = "/" + uri
+	}
+	return strings.TrimSuffix(link, "/") + uri
+}
+
+// getXML gets the resource and unmarshals it in v
+func (d *HPDevice) getXML(ctx context.Context, url string, v interface{}) (err error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := d.client().Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return HPDeviceError{"HPDevice.getXML", "Unexpected status " + resp.Status, nil}
+	}
+	buffer, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	return xml.Unmarshal(buffer, v)
+}
@@ -0,0 +1,149 @@
+package hpdevices
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const scanManifestXML = `<?xml version="1.0" encoding="UTF-8"?>
+<man:Manifest xmlns:man="http://www.hp.com/schemas/imaging/con/ledm/manifest/2009/03/16" xmlns:map="http://www.hp.com/schemas/imaging/con/ledm/resourcemap/2009/03/16" xmlns:dd="http://www.hp.com/schemas/imaging/con/dictionaries/1.0/" xmlns:scan="http://www.hp.com/schemas/imaging/con/cnx/scan/2008/08/19">
+	<map:ResourceMap>
+		<map:ResourceLink><dd:ResourceURI>/Scanner</dd:ResourceURI></map:ResourceLink>
+		<map:Resources>
+			<map:Resource>
+				<map:ResourceURI><dd:ResourceURI>/Caps</dd:ResourceURI></map:ResourceURI>
+				<map:ResourceType><scan:ScanResourceType>ScanCaps</scan:ScanResourceType></map:ResourceType>
+			</map:Resource>
+			<map:Resource>
+				<map:ResourceURI><dd:ResourceURI>/ScanJobs</dd:ResourceURI></map:ResourceURI>
+				<map:ResourceType>ScanJobs</map:ResourceType>
+			</map:Resource>
+		</map:Resources>
+	</map:ResourceMap>
+</man:Manifest>`
+
+func Test_Discover(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/DevMgmt/DiscoveryTree.xml":
+			fmt.Fprint(w, discoveryTreeXML)
+		case "/Scan/ScanManifest.xml":
+			fmt.Fprint(w, scanManifestXML)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	d := &HPDevice{URL: server.URL}
+	resources, err := d.Discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resources.Revision != "SVN-IPG-LEDM.216" || len(resources.Resources) != 4 {
+		t.Fatalf("unexpected resources %+v", resources)
+	}
+	if uri, ok := resources.Lookup("scan:ScanCaps"); !ok || uri != "/Scanner/Caps" {
+		t.Errorf("Lookup ScanCaps got %s, %v", uri, ok)
+	}
+
+	tests := []struct {
+		resource knownResource
+		want     string
+	}{
+		{scanCapsResource, server.URL + "/Scanner/Caps"},
+		{scanJobsResource, server.URL + "/Scanner/ScanJobs"},
+		{productConfigResource, server.URL + "/DevMgmt/ProductConfigDyn.xml"},
+		{eventTableResource, server.URL + "/EventMgmt/EventTable"}, // Not advertised
+	}
+	for _, test := range tests {
+		if got := d.endpoint(context.Background(), test.resource); got != test.want {
+			t.Errorf("endpoint %v got %s, want %s", test.resource.types, got, test.want)
+		}
+	}
+}
+
+func Test_EndpointWithoutDiscoveryTree(t *testing.T) {
+	var trees int
+	served := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/DevMgmt/DiscoveryTree.xml" && served:
+			fmt.Fprint(w, discoveryTreeXML)
+		case r.URL.Path == "/Scan/ScanManifest.xml" && served:
+			fmt.Fprint(w, scanManifestXML)
+		default:
+			http.NotFound(w, r)
+		}
+		if r.URL.Path == "/DevMgmt/DiscoveryTree.xml" {
+			trees++
+		}
+	}))
+	defer server.Close()
+	d := &HPDevice{URL: server.URL}
+	for i := 0; i < 2; i++ {
+		if got := d.endpoint(context.Background(), scanJobsResource); got != server.URL+"/Scan/Jobs" {
+			t.Errorf("endpoint got %s", got)
+		}
+	}
+	if trees != 1 {
+		t.Errorf("discovery tree asked %d times before DiscoverRetry", trees)
+	}
+
+	// The device answers again
+	defer func(retry time.Duration) { DiscoverRetry = retry }(DiscoverRetry)
+	DiscoverRetry = 0
+	served = true
+	if got := d.endpoint(context.Background(), scanJobsResource); got != server.URL+"/Scanner/ScanJobs" {
+		t.Errorf("endpoint got %s after a new discovery", got)
+	}
+}
+
+const jobsManifestXML = `<?xml version="1.0" encoding="UTF-8"?>
+<man:Manifest xmlns:man="http://www.hp.com/schemas/imaging/con/ledm/manifest/2009/03/16" xmlns:map="http://www.hp.com/schemas/imaging/con/ledm/resourcemap/2009/03/16" xmlns:dd="http://www.hp.com/schemas/imaging/con/dictionaries/1.0/">
+	<map:ResourceMap>
+		<map:ResourceLink><dd:ResourceURI>/Jobs</dd:ResourceURI></map:ResourceLink>
+		<map:Resources>
+			<map:Resource>
+				<map:ResourceURI><dd:ResourceURI>/JobList</dd:ResourceURI></map:ResourceURI>
+				<map:ResourceType>ScanJobs</map:ResourceType>
+			</map:Resource>
+			<map:Resource>
+				<map:ResourceURI><dd:ResourceURI>/Status</dd:ResourceURI></map:ResourceURI>
+				<map:ResourceType>ScanStatus</map:ResourceType>
+			</map:Resource>
+		</map:Resources>
+	</map:ResourceMap>
+</man:Manifest>`
+
+func Test_ScanResourcesInScanManifest(t *testing.T) {
+	// The jobs manifest comes first, and lists resources with the types of the scan ones
+	tree := strings.Replace(discoveryTreeXML, "<ledm:SupportedIfc>",
+		"<ledm:SupportedIfc><ledm:ManifestURI>/Jobs/JobsManifest.xml</ledm:ManifestURI><dd:ResourceType>ledm:hpLedmJobsManifest</dd:ResourceType></ledm:SupportedIfc><ledm:SupportedIfc>", 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/DevMgmt/DiscoveryTree.xml":
+			fmt.Fprint(w, tree)
+		case "/Jobs/JobsManifest.xml":
+			fmt.Fprint(w, jobsManifestXML)
+		case "/Scan/ScanManifest.xml":
+			fmt.Fprint(w, scanManifestXML)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	d := &HPDevice{URL: server.URL}
+	if got := d.endpoint(context.Background(), scanJobsResource); got != server.URL+"/Scanner/ScanJobs" {
+		t.Errorf("scan jobs endpoint got %s", got)
+	}
+	if got := d.endpoint(context.Background(), scanStatusResource); got != server.URL+"/Scan/Status" {
+		t.Errorf("scan status endpoint got %s", got)
+	}
+}
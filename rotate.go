@@ -0,0 +1,101 @@
+// rotate.go
+package hpdevices
+
+import (
+	"image"
+	"image/draw"
+	"strconv"
+	"strings"
+)
+
+// RotationMode tells how pages are turned upright according to the ImageOrientation given by the device
+type RotationMode int
+
+const (
+	RotateEXIF   RotationMode = iota // Default, JPEG pages given as streams are kept lossless with an EXIF orientation tag, decoded pages are turned
+	RotatePixels                     // Pages are decoded and turned, JPEG pages are encoded again
+	RotateNone                       // Pages are given as scanned
+)
+
+// orientationRotation gives the clockwise rotation, in degrees, turning upright
+// a page having the ImageOrientation. Back sides of duplex scans come Flipped
+// from some feeders, or Rotated180.
+func orientationRotation(orientation string) int {
+	switch orientation {
+	case "", "Normal":
+		return 0
+	case "Flipped", "UpsideDown":
+		return 180
+	}
+	for _, prefix := range []string{"Rotated", "Rotate"} {
+		if strings.HasPrefix(orientation, prefix) {
+			degrees, err := strconv.Atoi(strings.TrimPrefix(orientation, prefix))
+			if err == nil && degrees%90 == 0 {
+				return (degrees%360 + 360) % 360
+			}
+		}
+	}
+	TRACE.Println("orientationRotation: unknown orientation", orientation)
+	return 0
+}
+
+// rotateImage turns the image clockwise by a multiple of 90 degrees. Gray
+// and paletted images keep their type, others are given in RGBA.
+func rotateImage(img image.Image, degrees int) image.Image {
+	degrees = (degrees%360 + 360) % 360
+	if degrees%90 != 0 {
+		TRACE.Println("rotateImage: unsupported rotation", degrees)
+		return img
+	}
+	if degrees == 0 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	size := image.Rect(0, 0, w, h)
+	if degrees != 180 {
+		size = image.Rect(0, 0, h, w)
+	}
+
+	// Source and destination pixels, with the number of bytes per pixel
+	var (
+		src, dst             []byte
+		srcStride, dstStride int
+		pixelSize            int
+		rotated              image.Image
+	)
+	switch s := img.(type) {
+	case *image.Gray:
+		d := image.NewGray(size)
+		src, srcStride, dst, dstStride, pixelSize, rotated = s.Pix[s.PixOffset(b.Min.X, b.Min.Y):], s.Stride, d.Pix, d.Stride, 1, d
+	case *image.Paletted:
+		d := image.NewPaletted(size, s.Palette)
+		src, srcStride, dst, dstStride, pixelSize, rotated = s.Pix[s.PixOffset(b.Min.X, b.Min.Y):], s.Stride, d.Pix, d.Stride, 1, d
+	default:
+		rgba, ok := img.(*image.RGBA)
+		if !ok {
+			rgba = image.NewRGBA(image.Rect(0, 0, w, h))
+			draw.Draw(rgba, rgba.Bounds(), img, b.Min, draw.Src)
+		}
+		d := image.NewRGBA(size)
+		min := rgba.Bounds().Min
+		src, srcStride, dst, dstStride, pixelSize, rotated = rgba.Pix[rgba.PixOffset(min.X, min.Y):], rgba.Stride, d.Pix, d.Stride, 4, d
+	}
+
+	for y := 0; y < h; y++ {
+		row := src[y*srcStride:]
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch degrees {
+			case 90:
+				dx, dy = h-1-y, x
+			case 180:
+				dx, dy = w-1-x, h-1-y
+			case 270:
+				dx, dy = y, w-1-x
+			}
+			copy(dst[dy*dstStride+dx*pixelSize:], row[x*pixelSize:(x+1)*pixelSize])
+		}
+	}
+	return rotated
+}
@@ -0,0 +1,108 @@
+package hpdevices
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+func Test_OrientationRotation(t *testing.T) {
+	for orientation, expected := range map[string]int{
+		"":           0,
+		"Normal":     0,
+		"Flipped":    180,
+		"Rotated90":  90,
+		"Rotate270":  270,
+		"Rotated-90": 270,
+		"Sideways":   0,
+	} {
+		if r := orientationRotation(orientation); r != expected {
+			t.Errorf("%q gives %d, expected %d", orientation, r, expected)
+		}
+	}
+}
+
+func Test_RotateImage(t *testing.T) {
+	// 3x2 image:
+	// 1 2 3
+	// 4 5 6
+	g := image.NewGray(image.Rect(0, 0, 3, 2))
+	copy(g.Pix, []byte{1, 2, 3, 4, 5, 6})
+	for degrees, expected := range map[int][]byte{
+		90:  {4, 1, 5, 2, 6, 3},
+		180: {6, 5, 4, 3, 2, 1},
+		270: {3, 6, 2, 5, 1, 4},
+	} {
+		r := rotateImage(g, degrees).(*image.Gray)
+		if !bytes.Equal(r.Pix, expected) {
+			t.Errorf("%d: got %v, expected %v", degrees, r.Pix, expected)
+		}
+	}
+	if r := rotateImage(g, 90); r.Bounds() != image.Rect(0, 0, 2, 3) {
+		t.Errorf("unexpected bounds %v", r.Bounds())
+	}
+
+	// Sub-images are rotated from their bounds
+	sub := g.SubImage(image.Rect(1, 0, 3, 2)).(*image.Gray)
+	if r := rotateImage(sub, 180).(*image.Gray); !bytes.Equal(r.Pix, []byte{6, 5, 3, 2}) {
+		t.Errorf("sub-image: got %v", r.Pix)
+	}
+
+	p := image.NewPaletted(image.Rect(0, 0, 2, 1), bilevelPalette)
+	p.Pix[0] = 1
+	if r := rotateImage(p, 180).(*image.Paletted); !bytes.Equal(r.Pix, []byte{0, 1}) {
+		t.Errorf("paletted: got %v", r.Pix)
+	}
+
+	y := image.NewYCbCr(image.Rect(0, 0, 2, 1), image.YCbCrSubsampleRatio444)
+	y.Y[0], y.Y[1] = 0, 255
+	y.Cb[0], y.Cb[1], y.Cr[0], y.Cr[1] = 128, 128, 128, 128
+	r := rotateImage(y, 180).(*image.RGBA)
+	if c := r.At(0, 0).(color.RGBA); c.R != 255 {
+		t.Errorf("YCbCr: got %v", c)
+	}
+}
+
+func Test_RotationModes(t *testing.T) {
+	fastPolling(t)
+	if NewScanOptions().Rotation != RotateEXIF {
+		t.Error("JPEG pages not kept lossless by default")
+	}
+	for _, mode := range []RotationMode{RotateEXIF, RotateNone} {
+		f := newFakeScanner(t, 1)
+		f.orientations = map[int]string{1: "Rotated90"}
+		w := new(memoryPageWriter)
+		if err := f.device().Scan(context.Background(), NewScanOptions(WithRotation(mode)), w); err != nil {
+			t.Fatal(err)
+		}
+		page, info := w.pages[0].Bytes(), w.infos[0]
+		if info.Rotation != 0 || info.Width != 30 || info.Height != f.height {
+			t.Errorf("%d: pixels turned %+v", mode, info)
+		}
+		if mode == RotateNone {
+			if info.EXIFOrientation != 0 || !bytes.Equal(page, f.pages[0]) {
+				t.Errorf("page modified without rotation")
+			}
+			continue
+		}
+
+		// The EXIF segment follows the JFIF header if any, the rest is kept
+		app0 := 2
+		if f.pages[0][3] == 0xE0 {
+			app0 += 2 + int(f.pages[0][4])<<8 + int(f.pages[0][5])
+		}
+		exif := page[app0 : app0+36]
+		if info.EXIFOrientation != 6 || !bytes.Equal(exif[:2], []byte{0xFF, 0xE1}) || string(exif[4:8]) != "Exif" || exif[29] != 6 {
+			t.Errorf("no EXIF orientation: %+v % x", info, exif)
+		}
+		if !bytes.Equal(page[app0+36:], f.pages[0][app0:]) {
+			t.Errorf("JPEG stream modified")
+		}
+		if _, err := jpeg.Decode(bytes.NewReader(page)); err != nil {
+			t.Error(err)
+		}
+	}
+}
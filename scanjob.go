@@ -3,25 +3,62 @@ package hpdevices
 
 import (
 	"bytes"
+	"context"
 	"encoding/xml"
 	"errors"
+	"image"
+	"image/jpeg"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"strings"
 	"time"
+
+	"github.com/simulot/hpdevices/jpegfix"
 )
 
+// ErrScanCanceled is the error of a job canceled by its context or by ScanJob.Cancel.
+// Pages are downloaded and checked before being given to writers, so the unfinished
+// page of a canceled job is dropped, and writers only get complete pages.
+var ErrScanCanceled = errors.New("Scan job canceled")
+
+// ImageWriter gives a writer for each page. See PageWriter to get page information.
 type ImageWriter interface {
 	NewImageWriter() (io.WriteCloser, error)
 }
 
 type hpscanJob struct {
-	Device      *HPDevice
-	URL         string
-	ImageWriter ImageWriter
-	Http        http.Client
+	Device   *HPDevice
+	URL      string
+	Writer   PageWriter
+	Http     *http.Client
+	Settings scanSettings // Settings posted for the job
+
+	Rotation   RotationMode
+	Processors []PageProcessor
+
+	events   func(ScanEvent) // Receives job and page progress, may be nil
+	jobState string          // Last job state seen
+	preScan  pageState       // Last PreScanPage seen
+	postScan pageState       // Last PostScanPage seen
+	page     *preScanPage    // Page being downloaded
+	pending  *pendingPage    // Page downloaded, waiting for its PostScanPage
 }
 
+// pageState identifies a page step, to report changes only once
+type pageState struct {
+	PageNumber int
+	PageState  string
+}
+
+// rotatedJPEGQuality is used to encode again JPEG pages once rotated
+const rotatedJPEGQuality = 95
+
+var (
+	cancelTimeout    = 30 * time.Second // Time given to the device to cancel a job and go back to idle
+	idlePollInterval = time.Second      // Time between two queries of the scanner state during a cancellation
+)
+
 func defaultToneMapping() toneMap {
 	return toneMap{Gamma: 1000,
 		Brightness: 1000,
@@ -37,7 +74,7 @@ func defautScanSetting() scanSettings {
 		YResolution:        200,
 		XStart:             0,
 		YStart:             0,
-		Width:              2481,
+		Width:              2481, // A4, in 1/300 of inch. See WithPaperSize
 		Height:             3507,
 		Format:             "Jpeg",
 		CompressionQFactor: 0,
@@ -53,66 +90,125 @@ func defautScanSetting() scanSettings {
 
 }
 
+// NewScanJob scans with default settings, except for the source, resolution and color space.
+// It's kept for compatibility, Scan gives access to all settings.
 func (d *HPDevice) NewScanJob(imagewriter ImageWriter, source string, resolution int, colorspace string) (err error) {
+	opts := NewScanOptions(WithSource(InputSource(source)), WithResolution(resolution), WithColorSpace(colorspace))
+	return d.Scan(context.Background(), opts, AdaptImageWriter(imagewriter))
+}
+
+func (d *HPDevice) newScanJob(writer PageWriter, opts ScanOptions) *hpscanJob {
 	sj := new(hpscanJob)
 	sj.Device = d
-	sj.ImageWriter = imagewriter
+	sj.Writer = writer
+	sj.Http = d.client()
+	sj.Rotation = opts.Rotation
+	sj.Processors = opts.Processors
+	return sj
+}
 
-	ss := defautScanSetting()
-	ss.XResolution, ss.YResolution = resolution, resolution
-	ss.InputSource = string(source)
-	ss.ColorSpace = colorspace
+// run posts the job once the device is free, and handles its pages until it's completed
+func (sj *hpscanJob) run(ctx context.Context, ss scanSettings) (err error) {
+	release, err := sj.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	err = sj.post(ctx, ss)
+	if err != nil {
+		return err
+	}
+	return sj.poll(ctx)
+}
 
+// post sends the job settings to the device
+func (sj *hpscanJob) post(ctx context.Context, ss scanSettings) (err error) {
+	d := sj.Device
+	sj.Settings = ss
 	buffer, err := xml.Marshal(ss)
 	if err != nil {
 		return NewHPDeviceError("HPDevice.ScanJob", "", err)
 	}
 	r := bytes.NewReader(append([]byte(xmlHeader), buffer...))
 
-	resp, err := sj.Http.Post(d.URL+"/Scan/Jobs", "text/xml", r)
+	req, err := http.NewRequest("POST", d.endpoint(ctx, scanJobsResource), r)
 	if err != nil {
 		return NewHPDeviceError("HPDevice.ScanJob", "POST", err)
 	}
+	req.Header.Set("Content-Type", "text/xml")
+	resp, err := sj.Http.Do(req.WithContext(ctx))
+	if err != nil {
+		if ctx.Err() != nil {
+			return NewHPDeviceError("HPDevice.ScanJob", "POST", ErrScanCanceled)
+		}
+		return NewHPDeviceError("HPDevice.ScanJob", "POST", err)
+	}
 
 	defer resp.Body.Close()
 	if resp.StatusCode != 201 {
-		return NewHPDeviceError("HPDevice.ScanJob", "/Scan/Jobs Post job unexpected status code"+resp.Status, nil)
+		return NewHPDeviceError("HPDevice.ScanJob", "Post job unexpected status code"+resp.Status, nil)
 	}
-	sj.URL = resp.Header.Get("Location")
-	resp.Body.Close()
-
-	tick := time.NewTicker(10 * time.Second)
-	for _ = range tick.C {
+	sj.URL = d.resolve(resp.Header.Get("Location"))
+	return nil
+}
 
-		resp, err := sj.Http.Get(sj.URL)
-		if err != nil {
-			return NewHPDeviceError("HPDevice.ScanJob", "PageLoop", err)
-		}
-		defer resp.Body.Close()
-		if resp.StatusCode != 200 {
-			return NewHPDeviceError("HPDevice.ScanJob", "PageLoop Unexpected status "+resp.Status, nil)
+// poll follows the job state, and downloads pages when they are ready
+func (sj *hpscanJob) poll(ctx context.Context) (err error) {
+	defer func() {
+		if sj.pending != nil {
+			sj.pending.buf.Close()
+			sj.pending = nil
 		}
-		j := new(job)
-		buffer, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return NewHPDeviceError("HPDevice.ScanJob", "ScanJobLoop", err)
+	}()
+	poller := sj.Device.newJobPoller(ctx)
+	for first := true; ; first = false {
+		if !first && poller.wait(ctx) != nil {
+			return sj.abort()
 		}
 
-		err = xml.Unmarshal(buffer, j)
+		j, err := sj.getJob(ctx)
 		if err != nil {
-			return NewHPDeviceError("HPDevice.ScanJob", "ScanJobLoop", err)
+			if ctx.Err() != nil {
+				return sj.abort()
+			}
+			return err
+		}
+		poller.next(sj.report(j))
+		if post := j.ScanJob.PostScanPage; post != nil && sj.pending != nil && post.PageNumber == sj.pending.info.PageNumber {
+			err = sj.endPage(ctx, PageResult{post.PageNumber, post.PageState, post.TotalLines})
+			if err != nil {
+				if ctx.Err() != nil {
+					return sj.abort()
+				}
+				return err
+			}
 		}
-		resp.Body.Close()
 
 		Status := j.JobState
 		switch Status {
 		case "Processing":
 			// During PreScan phase, check if a page is ready to upload
-			if j.ScanJob.PreScanPage != nil && j.ScanJob.PreScanPage.PageState == "ReadyToUpload" {
-				err = sj.DownloadImage(sj.Device.URL+j.ScanJob.PreScanPage.BinaryURL, j.ScanJob.PreScanPage.BufferInfo.ImageHeight)
+			if pre := j.ScanJob.PreScanPage; pre != nil && pre.PageState == "ReadyToUpload" &&
+				(sj.pending == nil || sj.pending.info.PageNumber != pre.PageNumber) {
+				if sj.pending != nil {
+					// The PostScanPage of the previous page has been missed between two polls
+					err = sj.endPage(ctx, PageResult{PageNumber: sj.pending.info.PageNumber})
+					if err != nil {
+						if ctx.Err() != nil {
+							return sj.abort()
+						}
+						return err
+					}
+				}
+				sj.page = j.ScanJob.PreScanPage
+				err = sj.DownloadImage(ctx, sj.Device.resolve(j.ScanJob.PreScanPage.BinaryURL), j.ScanJob.PreScanPage.BufferInfo.ImageHeight)
 				if err != nil {
+					if ctx.Err() != nil {
+						return sj.abort()
+					}
 					return NewHPDeviceError("HPDevice.ScanJob", "DownloadImage", err)
 				}
+				poller.next(true) // The next page may follow shortly
 			}
 			// During PostScan phase, check if job is not canceled
 			if j.ScanJob.PostScanPage != nil && j.ScanJob.PostScanPage.PageState == "CanceledByDevice" {
@@ -121,136 +217,348 @@ func (d *HPDevice) NewScanJob(imagewriter ImageWriter, source string, resolution
 		case "Canceled":
 			return NewHPDeviceError("ScanBatch.ScanJobLoop", "Canceled status", nil)
 		case "Completed":
+			if sj.pending != nil {
+				// No PostScanPage for the last page
+				return sj.endPage(ctx, PageResult{PageNumber: sj.pending.info.PageNumber})
+			}
 			return nil
 		}
 	}
+}
 
+// abort cancels the job on the device, and waits until the scanner is idle again
+func (sj *hpscanJob) abort() error {
+	ctx, cancel := context.WithTimeout(context.Background(), cancelTimeout)
+	defer cancel()
+	err := sj.cancel(ctx)
+	if err == nil {
+		err = sj.waitIdle(ctx)
+	}
+	if err != nil {
+		ERROR.Println("HPDevice.ScanJob: cancel on device", sj.URL, err)
+	}
+	return NewHPDeviceError("HPDevice.ScanJob", "Canceled", ErrScanCanceled)
+}
+
+// cancel sets the job state to Canceled
+func (sj *hpscanJob) cancel(ctx context.Context) error {
+	buffer, err := xml.Marshal(jobStateUpdate{JobState: "Canceled"})
+	if err != nil {
+		return err
+	}
+	r := bytes.NewReader(append([]byte(xmlHeader), buffer...))
+	req, err := http.NewRequest("PUT", sj.URL, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/xml")
+	resp, err := sj.Http.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 && resp.StatusCode != 204 {
+		return HPDeviceError{"HPDevice.ScanJob", "Cancel unexpected status " + resp.Status, nil}
+	}
 	return nil
 }
 
-func (sj *hpscanJob) DownloadImage(image_url string, image_height int) (err error) {
+// waitIdle polls the scanner state until it's idle. Error states, like AdfError or
+// JamError, need someone at the scanner and fail at once.
+func (sj *hpscanJob) waitIdle(ctx context.Context) error {
+	for {
+		status, err := sj.Device.readScanStatus(ctx)
+		if err != nil {
+			return err
+		}
+		if status.ScannerState == "Idle" {
+			return nil
+		}
+		if strings.HasSuffix(status.ScannerState, "Error") {
+			return HPDeviceError{"HPDevice.ScanJob", "Scanner state " + status.ScannerState, ErrScannerError}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(idlePollInterval):
+		}
+	}
+}
+
+// report sends events for what has changed since the last poll, and tells if something has changed
+func (sj *hpscanJob) report(j *job) (changed bool) {
+	emit := func(e ScanEvent) {
+		changed = true
+		if sj.events != nil {
+			sj.events(e)
+		}
+	}
+	if j.JobState != sj.jobState {
+		sj.jobState = j.JobState
+		emit(ScanEvent{Type: JobStateChanged, JobState: j.JobState})
+	}
+	if pre := j.ScanJob.PreScanPage; pre != nil {
+		if state := (pageState{pre.PageNumber, pre.PageState}); state != sj.preScan {
+			sj.preScan = state
+			emit(ScanEvent{
+				Type:         PreScanPage,
+				JobState:     j.JobState,
+				PageNumber:   pre.PageNumber,
+				PageState:    pre.PageState,
+				ImageWidth:   pre.BufferInfo.ImageWidth,
+				ImageHeight:  pre.BufferInfo.ImageHeight,
+				BytesPerLine: pre.BufferInfo.BytesPerLine,
+			})
+		}
+	}
+	if post := j.ScanJob.PostScanPage; post != nil {
+		if state := (pageState{post.PageNumber, post.PageState}); state != sj.postScan {
+			sj.postScan = state
+			emit(ScanEvent{
+				Type:       PostScanPage,
+				JobState:   j.JobState,
+				PageNumber: post.PageNumber,
+				PageState:  post.PageState,
+				TotalLines: post.TotalLines,
+			})
+		}
+	}
+	return changed
+}
 
-	resp, err := sj.Http.Get(image_url)
+// getJob reads the job state
+func (sj *hpscanJob) getJob(ctx context.Context) (*job, error) {
+	req, err := http.NewRequest("GET", sj.URL, nil)
 	if err != nil {
-		return NewHPDeviceError("ScanJob.DownloadImage", "Get "+image_url, err)
+		return nil, NewHPDeviceError("HPDevice.ScanJob", "PageLoop", err)
+	}
+	resp, err := sj.Http.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, NewHPDeviceError("HPDevice.ScanJob", "PageLoop", err)
 	}
-
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return NewHPDeviceError("ScanJob.DownloadImage", "Unexpected status "+resp.Status, nil)
+		return nil, NewHPDeviceError("HPDevice.ScanJob", "PageLoop Unexpected status "+resp.Status, nil)
 	}
-
-	writer, err := sj.ImageWriter.NewImageWriter()
-
-	_, err = sj.FixJPEG(writer, resp.Body, image_height)
+	j := new(job)
+	buffer, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return NewHPDeviceError("ScanJob.DownloadImage", "Error during FixJPEG ", err)
+		return nil, NewHPDeviceError("HPDevice.ScanJob", "ScanJobLoop", err)
 	}
 
-	err = writer.Close()
+	err = xml.Unmarshal(buffer, j)
 	if err != nil {
-		return NewHPDeviceError("ScanJob.DownloadImage", "Error when closing writer", err)
+		return nil, NewHPDeviceError("HPDevice.ScanJob", "ScanJobLoop", err)
 	}
-	return err
-
+	return j, nil
 }
 
-/* jpegfix gets jpeg stream delivered by HP scanner when using ADF
-Segment DCT 0xFFC0 has a bad Lines field.
+// DownloadImage gets the page, and checks it. It's kept until the device reports the page is finished.
+func (sj *hpscanJob) DownloadImage(ctx context.Context, image_url string, image_height int) (err error) {
+	buf, err := sj.download(ctx, image_url)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ErrScanCanceled
+		}
+		return NewHPDeviceError("ScanJob.DownloadImage", "Get "+image_url, err)
+	}
+	info := newPageInfo(sj.page, sj.Settings)
+	info.Height = image_height
+	p := &pendingPage{url: image_url, info: info, buf: buf}
+	keep, err := sj.verify(ctx, p, PageResult{PageNumber: info.PageNumber})
+	if !keep {
+		p.buf.Close()
+		return err
+	}
+	sj.pending = p
+	return nil
+}
 
-*/
+// endPage checks the page kept by DownloadImage with the result given by the device, and writes it
+func (sj *hpscanJob) endPage(ctx context.Context, result PageResult) error {
+	p := sj.pending
+	sj.pending = nil
+	defer func() { p.buf.Close() }()
+	if result.PageState == "CanceledByDevice" {
+		return nil
+	}
+	keep, err := sj.verify(ctx, p, result)
+	if !keep {
+		return err
+	}
+	written, err := sj.writePage(p, result.TotalLines)
+	if err != nil || written == 0 {
+		return err
+	}
+	if err = sj.Writer.EndPage(result); err != nil {
+		return NewHPDeviceError("HPDevice.ScanJob", "EndPage", err)
+	}
+	return nil
+}
 
-func (sj *hpscanJob) FixJPEG(w io.Writer, r io.Reader, ActualLineNumber int) (written int64, err error) {
-	i := 0
-	buf := make([]byte, 256)
-	l, err := r.Read(buf)
-	if l != len(buf) || (buf[0] != 0xff && buf[1] != 0xd8) {
-		return 0, errors.New("Not a JPEG stream")
-	}
-	i = 2
-	for i < len(buf) && buf[i] == 0xff && buf[i+1] != 0xc0 {
-		size := int(buf[i+2])<<8 + int(buf[i+3]) + 2
-		if size < 0 {
-			return 0, errors.New("Frame lengh invalid")
+// verify checks the page, and asks the writer what to do when it fails.
+// The page is downloaded again while the device keeps it.
+func (sj *hpscanJob) verify(ctx context.Context, p *pendingPage, result PageResult) (keep bool, err error) {
+	retries := sj.Device.pageDownload().Retries
+	for attempt := 1; ; attempt++ {
+		err := p.check(result.TotalLines)
+		if err == nil {
+			return true, nil
 		}
-		i += size
-	}
-	if i >= len(buf) || (buf[i] != 0xff && buf[i+1] != 0xc0) {
-		return 0, errors.New("SOF marker not found in the header")
-	}
-
-	// Fix line number
-	i += 5
-	if buf[i] == 0xff && buf[i+1] == 0xff {
-		// Affected image
-		buf[i] = byte(ActualLineNumber >> 8)
-		buf[i+1] = byte(ActualLineNumber & 0x00ff)
-	}
-	// Write header
-	l, err = w.Write(buf)
-	written += int64(l) /* jpegfix gets jpeg stream delivered by HP scanner when using ADF
-	Segment DCT 0xFFC0 has a bad Lines field.
-
-	*/
-
-	// stream
-	if l == len(buf) && err == nil {
-		buf = make([]byte, 32*1024)
-		for {
-			nr, er := r.Read(buf)
-			if nr > 0 {
-				nw, ew := w.Write(buf[0:nr])
-				if nw > 0 {
-					written += int64(nw)
-				}
-				if ew != nil {
-					err = ew
-					break
-				}
-				if nr != nw {
-					err = io.ErrShortWrite
-					break
+		action := AbortJob
+		if h, ok := pageFailureHandler(sj.Writer); ok {
+			action = h.PageFailed(PageFailure{Info: p.info, Result: result, Attempt: attempt, Err: err})
+		}
+		if action == RetryPage && attempt > retries {
+			action = AbortJob
+		}
+		switch action {
+		case SkipPage:
+			TRACE.Println("ScanJob.verify: page", p.info.PageNumber, "skipped:", err)
+			return false, nil
+		case RetryPage:
+			TRACE.Println("ScanJob.verify: page", p.info.PageNumber, "downloaded again:", err)
+			buf, err := sj.download(ctx, p.url)
+			if err != nil {
+				if ctx.Err() != nil {
+					err = ErrScanCanceled
 				}
+				return false, NewHPDeviceError("ScanJob.verify", "Get "+p.url, err)
 			}
-			if er == io.EOF {
-				break
-			}
-			if er != nil {
-				err = er
-				break
-			}
+			p.buf.Close()
+			p.buf = buf
+		default:
+			return false, NewHPDeviceError("ScanJob.verify", "Page check", err)
 		}
 	}
-	return written, err
 }
 
-func (sj *hpscanJob) GetStatus() (*scanStatus, error) {
-	var ScanStatus *scanStatus
-	resp, err := sj.Http.Get(sj.Device.URL + "/Scan/Status")
+// writePage gives the page to the writer, with the lines reported by the device if any.
+// It tells how many pages have been written, processors can drop or split pages.
+func (sj *hpscanJob) writePage(p *pendingPage, totalLines int) (written int, err error) {
+	info := p.info
+	if totalLines > 0 {
+		info.Height = totalLines
+	}
+	rotation := orientationRotation(info.Orientation)
+	if sj.Rotation == RotateNone {
+		rotation = 0
+	}
+	_, isEncoder := sj.Writer.(ImageEncoder)
+	stream := info.Format != "Raw" && !isEncoder           // The writer takes the JPEG stream
+	lossless := rotation == 0 || sj.Rotation == RotateEXIF // The stream can be kept
+	if stream && lossless && len(sj.Processors) == 0 {
+		return 1, sj.writeStream(p, info, rotation)
+	}
+
+	body, err := p.buf.Reader()
+	if err != nil {
+		return 0, NewHPDeviceError("ScanJob.writePage", "Read page buffer", err)
+	}
+	img, err := sj.decodePage(body, info)
+	if err != nil {
+		return 0, NewHPDeviceError("ScanJob.writePage", "Decode", err)
+	}
+	decoded := info
+	if rotation != 0 {
+		img = rotateImage(img, rotation)
+		decoded.Rotation = rotation
+		decoded.Width, decoded.Height = img.Bounds().Dx(), img.Bounds().Dy()
+	}
+	pages, err := processPage(sj.Processors, Page{decoded, img})
 	if err != nil {
-		err = NewHPDeviceError("hpscanJob.GetStatus", "get", err)
+		return 0, NewHPDeviceError("ScanJob.writePage", "Process", err)
+	}
+	if stream && lossless && len(pages) == 1 && pages[0].Image == img {
+		// The processors only looked at the page, like BlankPageFilter, the stream
+		// is given as it is instead of being encoded again.
+		page := pages[0].Info
+		page.Rotation, page.Width, page.Height = 0, info.Width, info.Height
+		return 1, sj.writeStream(p, page, rotation)
 	}
+	for _, page := range pages {
+		if err = sj.writeImage(page.Info, page.Image); err != nil {
+			return written, err
+		}
+		written++
+	}
+	return written, nil
+}
 
-	if err == nil && resp.StatusCode != 200 {
-		err = NewHPDeviceError("hpscanJob.GetStatus", "Unexpected status"+resp.Status, err)
-		resp.Body.Close()
+// writeStream gives the JPEG stream of the page to the writer, with an EXIF orientation when turned
+func (sj *hpscanJob) writeStream(p *pendingPage, info PageInfo, rotation int) error {
+	body, err := p.buf.Reader()
+	if err != nil {
+		return NewHPDeviceError("ScanJob.writeStream", "Read page buffer", err)
+	}
+	if rotation != 0 {
+		info.EXIFOrientation = exifOrientation(rotation)
+		body, err = withEXIFOrientation(body, info.EXIFOrientation)
+		if err != nil {
+			return NewHPDeviceError("ScanJob.writeStream", "EXIF", err)
+		}
 	}
+	writer, err := sj.Writer.NewPage(info)
+	if err != nil {
+		return NewHPDeviceError("ScanJob.writeStream", "NewPage", err)
+	}
+	_, err = sj.FixJPEG(writer, body, info.Height)
+	if err != nil {
+		closeWithError(writer, err)
+		return NewHPDeviceError("ScanJob.writeStream", "Error during FixJPEG ", err)
+	}
+	err = writer.Close()
+	if err != nil {
+		return NewHPDeviceError("ScanJob.writeStream", "Error when closing writer", err)
+	}
+	return nil
+}
 
-	buffer, err := ioutil.ReadAll(resp.Body)
-	resp.Body.Close()
+// decodePage reads the page in an image
+func (sj *hpscanJob) decodePage(r io.Reader, info PageInfo) (image.Image, error) {
+	if info.Format != "Raw" {
+		var buffer bytes.Buffer
+		_, err := sj.FixJPEG(&buffer, r, info.Height)
+		if err != nil {
+			return nil, err
+		}
+		return jpeg.Decode(&buffer)
+	}
+	return DecodeRaw(r, RawImage{info.Width, info.Height, info.BytesPerLine, info.ColorSpace, info.BitDepth})
+}
 
-	if err != nil {
-		err = NewHPDeviceError("hpscanJob.GetStatus", "ReadAll", err)
+// writeImage gives a decoded page to the writer. When it takes only streams,
+// JPEG pages are given in JPEG, and Raw pages in PNG.
+func (sj *hpscanJob) writeImage(info PageInfo, img image.Image) error {
+	if encoder, ok := sj.Writer.(ImageEncoder); ok {
+		return encoder.EncodePage(info, img)
+	}
+	if info.Format == "Jpeg" {
+		return JPEGWriter{sj.Writer, rotatedJPEGQuality}.EncodePage(info, img)
 	}
+	return PNGWriter{sj.Writer}.EncodePage(info, img)
+}
 
-	if err == nil {
-		ScanStatus := new(scanStatus)
-		err = xml.Unmarshal(buffer, ScanStatus)
+// closeWithError closes the writer, giving it the error when it can take it
+func closeWithError(w io.WriteCloser, err error) error {
+	if c, ok := w.(interface{ CloseWithError(error) error }); ok {
+		return c.CloseWithError(err)
 	}
+	return w.Close()
+}
+
+// FixJPEG copies the JPEG stream, with the line count of its header fixed.
+// The HP feeders give 0xFFFF lines, see the jpegfix package.
+func (sj *hpscanJob) FixJPEG(w io.Writer, r io.Reader, ActualLineNumber int) (written int64, err error) {
+	return jpegfix.Fix(w, r, ActualLineNumber)
+}
+
+func (sj *hpscanJob) GetStatus() (*scanStatus, error) {
+	status, err := sj.Device.readScanStatus(context.Background())
 	if err != nil {
-		err = NewHPDeviceError("hpscanJob.GetStatus", "Unmarshal", err)
+		return nil, NewHPDeviceError("hpscanJob.GetStatus", "", err)
 	}
-	return ScanStatus, err
+	return status, nil
 }
 
 func (sj *hpscanJob) GetSource() (source string, err error) {
@@ -2,6 +2,7 @@ package hpdevices
 
 import (
 	"bytes"
+	"context"
 	"encoding/xml"
 	"fmt"
 	"io"
@@ -42,6 +43,7 @@ type DestinationSettings struct {
 	Verso       bool // True when the current job should be merged with previous to become the second side
 	Resolution  int
 	ColorSpace  string
+	BlankPages  *BlankPageFilter // When set, blank pages are dropped before reaching the DocumentBatchHandler
 }
 
 type DocumentBatchHandlerFactory func(doctype string, destination *DestinationSettings, format string, previousbatch DocumentBatchHandler) (DocumentBatchHandler, error)
@@ -82,6 +84,20 @@ func NewScanToPC(Device *HPDevice, documentBatchHandlerFactory DocumentBatchHand
 	return stp, err
 }
 
+// scan runs a scan job for the destination, and gives the pages to the DocumentBatchHandler.
+// Both sides are scanned when chosen on the device panel for a feeder scan.
+func (stp *hpscanToPC) scan(destination *DestinationSettings, walkup *walkupScanToCompDestination) error {
+	opts := NewScanOptions(WithSource(InputSource(stp.scanSource)), WithResolution(destination.Resolution), WithColorSpace(destination.ColorSpace))
+	if walkup != nil && walkup.WalkupScanToCompSettings != nil &&
+		walkup.WalkupScanToCompSettings.ScanSettings.ScanPlexMode == "Duplex" && opts.InputSource == SourceAdf {
+		opts.Duplex = true
+	}
+	if destination.BlankPages != nil {
+		opts.Processors = append(opts.Processors, *destination.BlankPages)
+	}
+	return stp.Device.Scan(context.Background(), opts, AdaptImageWriter(stp.DocumentBatchHandler))
+}
+
 //Register: Register destinations on the device
 
 func (stp *hpscanToPC) Register(HostName string, Destinations []DestinationSettings) (err error) {
@@ -97,7 +113,7 @@ func (stp *hpscanToPC) Register(HostName string, Destinations []DestinationSetti
 		}
 
 		r := bytes.NewReader(append([]byte(xmlHeader), buffer...))
-		resp, err := http.Post(stp.Device.URL+"/WalkupScanToComp/WalkupScanToCompDestinations", "text/xml", r)
+		resp, err := stp.Device.client().Post(stp.Device.endpoint(context.Background(), walkupDestinationsResource), "text/xml", r)
 		if err != nil {
 			return NewHPDeviceError("hpscanToPC.Register", "POST", err)
 		}
@@ -189,7 +205,7 @@ func (stp *hpscanToPC) EventLoop(eventsChannel chan *eventTable, errorsChannel c
 	defer TRACE.Println("Stop EventLoop #", elc)
 
 	// on call to get firts events and e-tag
-	resp, err := http.Get(stp.Device.URL + "/EventMgmt/EventTable")
+	resp, err := stp.Device.client().Get(stp.Device.endpoint(context.Background(), eventTableResource))
 	if err != nil {
 		err = NewHPDeviceError("hpscanToPC.EventLoop", "", err)
 	}
@@ -228,7 +244,7 @@ func (stp *hpscanToPC) EventLoop(eventsChannel chan *eventTable, errorsChannel c
 				return
 			default:
 				timeoutClient = newTimeoutClient(2*time.Second, eventLoopTimeOut+10*time.Second) // 2 sec for the header, 1.5 * HP device timeout for getting the boddy
-				request, err = http.NewRequest("GET", stp.Device.URL+"/EventMgmt/EventTable?timeout="+fmt.Sprintf("%d", int(eventLoopTimeOut.Seconds())*10), nil)
+				request, err = http.NewRequest("GET", stp.Device.endpoint(context.Background(), eventTableResource)+"?timeout="+fmt.Sprintf("%d", int(eventLoopTimeOut.Seconds())*10), nil)
 				if err != nil {
 					err = NewHPDeviceError("hpscanToPC.EventLoop", "NewRequest", err)
 				}
@@ -376,7 +392,7 @@ func (stp *hpscanToPC) GetWalkupScanToCompDestinations(uri string) (*walkupScanT
 	TRACE.Println("hpscanToPC.WalkupScanToCompDestinations entering")
 	var dest *walkupScanToCompDestination
 	//TODO: Is this call absolutly necessaire?
-	resp, err := http.Get(stp.Device.URL + "/WalkupScanToComp/WalkupScanToCompDestinations")
+	resp, err := stp.Device.client().Get(stp.Device.endpoint(context.Background(), walkupDestinationsResource))
 	if err != nil {
 		err = NewHPDeviceError("hpscanToPC.WalkupScanToCompDestinations", "", err)
 	}
@@ -408,7 +424,7 @@ func (stp *hpscanToPC) GetWalkupScanToCompDestinations(uri string) (*walkupScanT
 
 		if err == nil {
 			// Call the given URI
-			resp, err = http.Get(stp.Device.URL + uri)
+			resp, err = stp.Device.client().Get(stp.Device.resolve(uri))
 		}
 
 		if err == nil && resp.StatusCode != 200 {
@@ -438,7 +454,7 @@ func (stp *hpscanToPC) GetWalkupScanToCompDestinations(uri string) (*walkupScanT
 func (stp *hpscanToPC) WalkupScanToCompEvent(Destination *DestinationSettings, walkupScanToCompDestination *walkupScanToCompDestination) error {
 	// Handle a scan event
 	TRACE.Println("hpscanToPC.WalkupScanToCompEvent", "entering")
-	resp, err := http.Get(stp.Device.URL + "/WalkupScanToComp/WalkupScanToCompEvent")
+	resp, err := stp.Device.client().Get(stp.Device.endpoint(context.Background(), walkupEventResource))
 	if err != nil {
 		err = NewHPDeviceError("hpscanToPC.WalkupScanToCompEvent", "", err)
 	}
@@ -481,7 +497,7 @@ func (stp *hpscanToPC) WalkupScanToCompEvent(Destination *DestinationSettings, w
 					err = NewHPDeviceError("hpscanToPC.WalkupScanToCompEvent", "DocumentBatchHandlerFactory", err)
 				}
 				//TODO: ScanSource
-				err = stp.Device.NewScanJob(stp.DocumentBatchHandler, stp.scanSource, Destination.Resolution, Destination.ColorSpace)
+				err = stp.scan(Destination, walkupScanToCompDestination)
 				if err != nil {
 					err = NewHPDeviceError("hpscanToPC.WalkupScanToCompEvent", "ScanRequested/NewScanJob", err)
 				}
@@ -492,7 +508,7 @@ func (stp *hpscanToPC) WalkupScanToCompEvent(Destination *DestinationSettings, w
 					err = NewHPDeviceError("hpscanToPC.WalkupScanToCompEvent", "recieved ScanNewPageRequested, but DocumentBatchHandlerFactory is nil", nil)
 				}
 				//TODO: ScanSource
-				err = stp.Device.NewScanJob(stp.DocumentBatchHandler, stp.scanSource, Destination.Resolution, Destination.ColorSpace)
+				err = stp.scan(Destination, walkupScanToCompDestination)
 				if err != nil {
 					err = NewHPDeviceError("hpscanToPC.WalkupScanToCompEvent", "ScanNewPageRequested/NewScanJob", err)
 				}
@@ -0,0 +1,102 @@
+// slp.go
+package hpdevices
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// Minimal SLPv2 (RFC 2608) client, used to collect every HP device answering
+// to a service request during a probe window.
+
+const (
+	slpMulticastAddr = "239.255.255.253:427"
+	slpHPServiceType = "service:x-hpnp-discover"
+	slpVersion       = 2
+	slpSrvRqst       = 1
+	slpSrvRply       = 2
+	slpFlagMcast     = 0x2000
+)
+
+// slpServiceRequest builds a multicast SrvRqst message for the given service type
+func slpServiceRequest(xid uint16, serviceType string) []byte {
+	lang := "en"
+	scope := "default"
+	body := make([]byte, 0, 64)
+	body = appendSLPString(body, "") // PRList
+	body = appendSLPString(body, serviceType)
+	body = appendSLPString(body, scope)
+	body = appendSLPString(body, "") // Predicate
+	body = appendSLPString(body, "") // SLP SPI
+
+	length := 14 + len(lang) + len(body)
+	msg := make([]byte, 14, length)
+	msg[0] = slpVersion
+	msg[1] = slpSrvRqst
+	msg[2], msg[3], msg[4] = byte(length>>16), byte(length>>8), byte(length)
+	binary.BigEndian.PutUint16(msg[5:7], slpFlagMcast)
+	// msg[7:10] Next extension offset is 0
+	binary.BigEndian.PutUint16(msg[10:12], xid)
+	binary.BigEndian.PutUint16(msg[12:14], uint16(len(lang)))
+	msg = append(msg, lang...)
+	return append(msg, body...)
+}
+
+func appendSLPString(b []byte, s string) []byte {
+	b = append(b, byte(len(s)>>8), byte(len(s)))
+	return append(b, s...)
+}
+
+// isSLPReply checks that the message is a successful SrvRply to our request
+func isSLPReply(msg []byte, xid uint16) bool {
+	if len(msg) < 16 || msg[0] != slpVersion || msg[1] != slpSrvRply {
+		return false
+	}
+	if binary.BigEndian.Uint16(msg[10:12]) != xid {
+		return false
+	}
+	langLen := int(binary.BigEndian.Uint16(msg[12:14]))
+	if len(msg) < 14+langLen+2 {
+		return false
+	}
+	return binary.BigEndian.Uint16(msg[14+langLen:]) == 0 // Error code
+}
+
+// probeSLP sends the HP discovery request and returns the addresses of all
+// devices answering before the end of the window.
+func probeSLP(window time.Duration) (addresses []string, err error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, NewHPDeviceError("probeSLP", "ListenUDP", err)
+	}
+	defer conn.Close()
+
+	group, err := net.ResolveUDPAddr("udp4", slpMulticastAddr)
+	if err != nil {
+		return nil, NewHPDeviceError("probeSLP", "ResolveUDPAddr", err)
+	}
+	xid := uint16(rand.Intn(0x10000))
+	_, err = conn.WriteToUDP(slpServiceRequest(xid, slpHPServiceType), group)
+	if err != nil {
+		return nil, NewHPDeviceError("probeSLP", "WriteToUDP", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(window))
+	seen := make(map[string]bool)
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			// The deadline ends the probe window
+			break
+		}
+		ip := from.IP.String()
+		if isSLPReply(buf[:n], xid) && !seen[ip] {
+			seen[ip] = true
+			addresses = append(addresses, ip)
+		}
+	}
+	return addresses, nil
+}
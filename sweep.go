@@ -0,0 +1,120 @@
+// sweep.go
+package hpdevices
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// MaxSweepHosts limits the size of the network range swept by a SweepDiscoverer
+var MaxSweepHosts = 65536
+
+// SweepDiscoverer finds devices by asking each host of a network range for its
+// LEDM discovery tree. It's the fallback when neither SLP nor multicast DNS
+// gets through, across routed VLANs for instance.
+// The probe window isn't used, the sweep lasts until each host is probed.
+type SweepDiscoverer struct {
+	Network   string        // Range to sweep in CIDR notation, like 192.168.1.0/24
+	Workers   int           // Number of hosts probed at once, default is 64
+	Timeout   time.Duration // Time given to each host and endpoint, default is 1 second
+	Endpoints []Endpoint    // Endpoints tried on each host, default is EndpointCandidates
+}
+
+func (s *SweepDiscoverer) Probe(window time.Duration) (devices []*HPDevice, err error) {
+	hosts, err := sweepHosts(s.Network)
+	if err != nil {
+		return nil, err
+	}
+	workers := s.Workers
+	if workers <= 0 {
+		workers = 64
+	}
+
+	jobs := make(chan string)
+	found := make(chan *HPDevice)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for host := range jobs {
+				if d := s.probeHost(host); d != nil {
+					found <- d
+				}
+			}
+		}()
+	}
+	go func() {
+		for _, host := range hosts {
+			jobs <- host
+		}
+		close(jobs)
+		wg.Wait()
+		close(found)
+	}()
+
+	for d := range found {
+		devices = append(devices, d)
+	}
+	return devices, nil
+}
+
+// probeHost returns the device served by the host, or nil
+func (s *SweepDiscoverer) probeHost(host string) *HPDevice {
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	endpoints := s.Endpoints
+	if len(endpoints) == 0 {
+		endpoints = EndpointCandidates
+	}
+	d := &HPDevice{IPAddress: host}
+	for _, endpoint := range endpoints {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		base, err := d.tryEndpoint(ctx, baseURL(endpoint.Scheme, host, endpoint.Port))
+		cancel()
+		if err == nil {
+			d.URL = base
+			return d
+		}
+	}
+	return nil
+}
+
+// sweepHosts lists the host addresses of the network
+func sweepHosts(network string) (hosts []string, err error) {
+	ip, ipnet, err := net.ParseCIDR(network)
+	if err != nil {
+		return nil, NewHPDeviceError("SweepDiscoverer", "Invalid network "+network, err)
+	}
+	ones, bits := ipnet.Mask.Size()
+	if bits-ones > 30 || 1<<uint(bits-ones) > MaxSweepHosts {
+		return nil, NewHPDeviceError("SweepDiscoverer", "Network too large "+network, nil)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	for ip := ip.Mask(ipnet.Mask); ipnet.Contains(ip); ip = nextIP(ip) {
+		hosts = append(hosts, ip.String())
+	}
+	// Skip network and broadcast addresses
+	if ip.To4() != nil && bits-ones > 1 {
+		hosts = hosts[1 : len(hosts)-1]
+	}
+	return hosts, nil
+}
+
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
@@ -0,0 +1,56 @@
+package hpdevices
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func Test_SweepHosts(t *testing.T) {
+	hosts, err := sweepHosts("192.168.1.0/30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(hosts) != "[192.168.1.1 192.168.1.2]" {
+		t.Errorf("unexpected hosts %v", hosts)
+	}
+	if _, err = sweepHosts("10.0.0.0/8"); err == nil {
+		t.Error("large network accepted")
+	}
+}
+
+func Test_SweepDiscoverer(t *testing.T) {
+	ledm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/DevMgmt/DiscoveryTree.xml" {
+			fmt.Fprint(w, discoveryTreeXML)
+		}
+	}))
+	defer ledm.Close()
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<?xml version="1.0"?><DiscoveryTree xmlns="urn:other"/>`)
+	}))
+	defer web.Close()
+
+	port := func(s *httptest.Server) int {
+		_, p, _ := net.SplitHostPort(s.Listener.Addr().String())
+		n, _ := strconv.Atoi(p)
+		return n
+	}
+	s := &SweepDiscoverer{
+		Network:   "127.0.0.1/32",
+		Workers:   4,
+		Timeout:   time.Second,
+		Endpoints: []Endpoint{{"http", port(web)}, {"http", port(ledm)}},
+	}
+	devices, err := s.Probe(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 1 || devices[0].URL != ledm.URL || devices[0].IPAddress != "127.0.0.1" {
+		t.Fatalf("unexpected devices %v", devices)
+	}
+}
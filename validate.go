@@ -0,0 +1,204 @@
+// validate.go
+package hpdevices
+
+import (
+	"context"
+	"strconv"
+	"strings"
+)
+
+// ScanValidation tells how scan settings are checked against the device capabilities before posting a job
+type ScanValidation int
+
+const (
+	ValidationDefault ScanValidation = iota // Jobs use the device ScanValidation, ValidationNone for the device
+	ValidationNone                          // Settings are sent as given
+	ValidationStrict                        // Unsupported settings make the job fail
+	ValidationSnap                          // Settings are moved to the nearest supported value when possible
+)
+
+// SettingViolation is a scan setting the device doesn't support
+type SettingViolation struct {
+	Setting string // InputSource, Duplex, ColorType, Resolution, Format, Area
+	Value   string
+	Reason  string
+}
+
+// ScanSettingsError lists every unsupported setting of a job
+type ScanSettingsError struct {
+	Violations []SettingViolation
+}
+
+func (e *ScanSettingsError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		messages[i] = v.Setting + " " + v.Value + ": " + v.Reason
+	}
+	return "Unsupported scan settings: " + strings.Join(messages, "; ")
+}
+
+func (e *ScanSettingsError) add(setting, value, reason string) {
+	e.Violations = append(e.Violations, SettingViolation{setting, value, reason})
+}
+
+// colorType gives the ScanCaps color type of a color space and bit depth
+func colorType(colorSpace string, bitDepth int) string {
+	switch {
+	case colorSpace == "Gray" && bitDepth == 1:
+		return "K1"
+	case colorSpace == "Gray" && bitDepth == 8:
+		return "Gray8"
+	case colorSpace == "Color" && bitDepth == 8:
+		return "Color8"
+	}
+	return colorSpace + strconv.Itoa(bitDepth)
+}
+
+// validateScan fetches the capabilities and checks the settings with the device validation mode.
+// Duplex is always checked, it's never sent to a device that doesn't list it.
+func (d *HPDevice) validateScan(ctx context.Context, ss *scanSettings, mode ScanValidation) error {
+	if mode == ValidationDefault {
+		mode = ValidationNone
+	}
+	if mode == ValidationNone && !isDuplex(ss) {
+		return nil
+	}
+	caps, err := d.ScanCapabilities(ctx)
+	if err != nil {
+		return err
+	}
+	if mode == ValidationNone {
+		e := new(ScanSettingsError)
+		if source, ok := caps.Source(InputSource(ss.InputSource)); !ok || !validateDuplex(source, ss, false) {
+			e.add("Duplex", ss.InputSource, "not supported")
+			return e
+		}
+		return nil
+	}
+	return validateScanSettings(caps, ss, mode == ValidationSnap)
+}
+
+func isDuplex(ss *scanSettings) bool {
+	return contains(ss.AdfOptions, "Duplex")
+}
+
+// validateDuplex tells if the source can scan both sides, when asked. When snap
+// is true, an unsupported duplex scan is turned into a simplex one.
+func validateDuplex(source *SourceCapabilities, ss *scanSettings, snap bool) bool {
+	if !isDuplex(ss) || source.HasAdfOption("Duplex") {
+		return true
+	}
+	if snap {
+		TRACE.Println("validateScanSettings: Duplex not supported on", ss.InputSource, ", snapped to simplex")
+		ss.AdfOptions = nil
+		return true
+	}
+	return false
+}
+
+// validateScanSettings checks the settings against the capabilities. When snap
+// is true, settings are moved to the nearest supported values, and only
+// settings that can't be moved are reported.
+func validateScanSettings(caps *ScanCapabilities, ss *scanSettings, snap bool) error {
+	e := new(ScanSettingsError)
+	source, ok := caps.Source(InputSource(ss.InputSource))
+	if !ok {
+		e.add("InputSource", ss.InputSource, "not available")
+		return e
+	}
+
+	if !validateDuplex(source, ss, snap) {
+		e.add("Duplex", ss.InputSource, "not supported")
+	}
+
+	ct := colorType(ss.ColorSpace, ss.BitDepth)
+	entry, ok := caps.ColorEntry(ct)
+	if !ok {
+		e.add("ColorType", ct, "not available")
+	} else {
+		validateResolution(e, source, ct, ss, snap)
+		if !contains(entry.Formats, ss.Format) {
+			if snap && len(entry.Formats) > 0 {
+				format := entry.Formats[0]
+				if contains(entry.Formats, "Jpeg") {
+					format = "Jpeg"
+				}
+				TRACE.Println("validateScanSettings: Format", ss.Format, "snapped to", format)
+				ss.Format = format
+			} else {
+				e.add("Format", ss.Format, "not available for "+ct+", use one of "+strings.Join(entry.Formats, ","))
+			}
+		}
+	}
+	validateArea(e, source, ss, snap)
+
+	if len(e.Violations) > 0 {
+		return e
+	}
+	return nil
+}
+
+func validateResolution(e *ScanSettingsError, source *SourceCapabilities, ct string, ss *scanSettings, snap bool) {
+	resolutions := source.ResolutionsFor(ct)
+	for _, r := range resolutions {
+		if r.XResolution == ss.XResolution && r.YResolution == ss.YResolution {
+			return
+		}
+	}
+	value := strconv.Itoa(ss.XResolution) + "x" + strconv.Itoa(ss.YResolution)
+	if !snap || len(resolutions) == 0 {
+		e.add("Resolution", value, "not supported for "+ct+" on "+ss.InputSource)
+		return
+	}
+	nearest := resolutions[0]
+	for _, r := range resolutions {
+		if abs(r.XResolution-ss.XResolution) < abs(nearest.XResolution-ss.XResolution) {
+			nearest = r
+		}
+	}
+	TRACE.Println("validateScanSettings: Resolution", value, "snapped to", nearest.XResolution)
+	ss.XResolution, ss.YResolution = nearest.XResolution, nearest.YResolution
+}
+
+// validateArea checks the scan area, in 1/300 inch, fits into the source
+func validateArea(e *ScanSettingsError, source *SourceCapabilities, ss *scanSettings, snap bool) {
+	value := strconv.Itoa(ss.XStart) + "," + strconv.Itoa(ss.YStart) + " " + strconv.Itoa(ss.Width) + "x" + strconv.Itoa(ss.Height)
+	fits := ss.XStart >= 0 && ss.YStart >= 0 &&
+		ss.Width >= source.MinWidth && ss.Height >= source.MinHeight &&
+		ss.XStart+ss.Width <= source.MaxWidth && ss.YStart+ss.Height <= source.MaxHeight
+	if fits {
+		return
+	}
+	if !snap {
+		e.add("Area", value, "doesn't fit into "+strconv.Itoa(source.MaxWidth)+"x"+strconv.Itoa(source.MaxHeight)+
+			" with a minimum of "+strconv.Itoa(source.MinWidth)+"x"+strconv.Itoa(source.MinHeight))
+		return
+	}
+	ss.XStart, ss.Width = clampSpan(ss.XStart, ss.Width, source.MinWidth, source.MaxWidth)
+	ss.YStart, ss.Height = clampSpan(ss.YStart, ss.Height, source.MinHeight, source.MaxHeight)
+	TRACE.Println("validateScanSettings: Area", value, "snapped to", ss.XStart, ss.YStart, ss.Width, ss.Height)
+}
+
+// clampSpan fits start and length into [0, max], keeping at least min of length
+func clampSpan(start, length, min, max int) (int, int) {
+	if start < 0 {
+		start = 0
+	}
+	if length > max {
+		length = max
+	}
+	if length < min {
+		length = min
+	}
+	if start+length > max {
+		start = max - length
+	}
+	return start, length
+}
+
+func abs(i int) int {
+	if i < 0 {
+		return -i
+	}
+	return i
+}
@@ -0,0 +1,79 @@
+package hpdevices
+
+import (
+	"errors"
+	"testing"
+)
+
+func Test_ValidateScanSettings(t *testing.T) {
+	caps := testScanCapabilities(t)
+
+	ss := defautScanSetting()
+	if err := validateScanSettings(caps, &ss, false); err != nil {
+		t.Errorf("default settings rejected: %v", err)
+	}
+
+	ss = defautScanSetting()
+	ss.InputSource = "Adf"
+	ss.XResolution, ss.YResolution = 600, 600
+	ss.ColorSpace, ss.BitDepth = "Gray", 1
+	ss.Width, ss.Height = 3000, 3507
+	err := validateScanSettings(caps, &ss, false)
+	var e *ScanSettingsError
+	if !errors.As(err, &e) {
+		t.Fatalf("expected ScanSettingsError, got %v", err)
+	}
+	settings := map[string]bool{}
+	for _, v := range e.Violations {
+		settings[v.Setting] = true
+	}
+	if len(e.Violations) != 3 || !settings["Resolution"] || !settings["Format"] || !settings["Area"] {
+		t.Errorf("unexpected violations %+v", e.Violations)
+	}
+
+	if err = validateScanSettings(caps, &ss, true); err != nil {
+		t.Fatalf("snap failed: %v", err)
+	}
+	if ss.XResolution != 300 || ss.YResolution != 300 || ss.Format != "Raw" || ss.Width != 2550 || ss.XStart != 0 {
+		t.Errorf("unexpected snapped settings %+v", ss)
+	}
+
+	ss = defautScanSetting()
+	ss.InputSource = "Film"
+	if err = validateScanSettings(caps, &ss, true); err == nil {
+		t.Error("unknown source accepted")
+	}
+}
+
+func Test_ValidateDuplex(t *testing.T) {
+	caps := testScanCapabilities(t)
+	o := NewScanOptions(WithDuplex())
+	ss := o.scanSettings()
+	if err := validateScanSettings(caps, &ss, false); err != nil {
+		t.Errorf("duplex refused on the feeder: %v", err)
+	}
+
+	ss.InputSource = "Platen"
+	err := validateScanSettings(caps, &ss, false)
+	if e, ok := err.(*ScanSettingsError); !ok || e.Violations[0].Setting != "Duplex" {
+		t.Errorf("duplex accepted on the platen: %v", err)
+	}
+	if err = validateScanSettings(caps, &ss, true); err != nil || isDuplex(&ss) {
+		t.Errorf("duplex not snapped to simplex: %v %v", err, ss.AdfOptions)
+	}
+}
+
+func Test_ClampSpan(t *testing.T) {
+	tests := []struct{ start, length, min, max, wantStart, wantLength int }{
+		{0, 2481, 8, 2550, 0, 2481},
+		{200, 2481, 8, 2550, 69, 2481},
+		{-10, 4, 8, 2550, 0, 8},
+		{0, 3000, 8, 2550, 0, 2550},
+	}
+	for _, test := range tests {
+		start, length := clampSpan(test.start, test.length, test.min, test.max)
+		if start != test.wantStart || length != test.wantLength {
+			t.Errorf("clampSpan(%d, %d) = %d, %d", test.start, test.length, start, length)
+		}
+	}
+}
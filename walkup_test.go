@@ -0,0 +1,53 @@
+package hpdevices
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+)
+
+// memoryBatch keeps the pages of a walk-up scan
+type memoryBatch struct {
+	pages []*bytes.Buffer
+}
+
+func (b *memoryBatch) NewImageWriter() (io.WriteCloser, error) {
+	page := new(bytes.Buffer)
+	b.pages = append(b.pages, page)
+	return nopWriteCloser{page}, nil
+}
+
+func (b *memoryBatch) CloseDocumentBatch() error { return nil }
+
+func Test_WalkupDuplexScan(t *testing.T) {
+	fastPolling(t)
+	f := newFakeScanner(t, 2)
+	d := f.device()
+	d.Client.Transport = handlerTransport{http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/WalkupScanToComp/WalkupScanToCompEvent" {
+			fmt.Fprint(w, `<WalkupScanToCompEvent xmlns="http://www.hp.com/schemas/imaging/con/ledm/walkupscan/2010/09/28"><WalkupScanToCompEventType>ScanRequested</WalkupScanToCompEventType></WalkupScanToCompEvent>`)
+			return
+		}
+		f.ServeHTTP(w, r)
+	})}
+	batch := new(memoryBatch)
+	stp := &hpscanToPC{
+		Device: d,
+		DocumentBatchHandlerFactory: func(string, *DestinationSettings, string, DocumentBatchHandler) (DocumentBatchHandler, error) {
+			return batch, nil
+		},
+		scanSource: "Adf",
+	}
+	walkup := &walkupScanToCompDestination{WalkupScanToCompSettings: &walkupScanToCompSettings{
+		ScanSettings: scanType{ScanPlexMode: "Duplex"},
+		Shortcut:     "SaveDocument1",
+	}}
+	if err := stp.WalkupScanToCompEvent(&DestinationSettings{Resolution: 200, ColorSpace: "Gray"}, walkup); err != nil {
+		t.Fatal(err)
+	}
+	if !isDuplex(&f.settings) || len(batch.pages) != 2 {
+		t.Errorf("duplex not scanned, options %v, %d pages", f.settings.AdfOptions, len(batch.pages))
+	}
+}
@@ -0,0 +1,204 @@
+// watcher.go
+package hpdevices
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type DeviceEventType int
+
+const (
+	DeviceAdded   DeviceEventType = iota // A new device answers
+	DeviceUpdated                        // A known device has changed of address
+	DeviceRemoved                        // A known device doesn't answer anymore
+)
+
+func (t DeviceEventType) String() string {
+	switch t {
+	case DeviceAdded:
+		return "Added"
+	case DeviceUpdated:
+		return "Updated"
+	case DeviceRemoved:
+		return "Removed"
+	}
+	return "Unknown"
+}
+
+type DeviceEvent struct {
+	Type              DeviceEventType
+	Device            *HPDevice
+	PreviousIPAddress string // Set for DeviceUpdated events
+}
+
+// DefaultWatchInterval is used by watchers having no Interval
+var DefaultWatchInterval = time.Minute
+
+// DeviceWatcher probes periodically the network and reports devices appearing,
+// changing of address or disappearing. Devices are tracked by UUID or serial
+// number, so a new DHCP lease is seen as an update of the same device.
+// Events give devices of their own, devices already received are never changed.
+type DeviceWatcher struct {
+	Discoverer Discoverer    // Default is DefaultDiscoverer
+	Interval   time.Duration // Time between two probes, default is DefaultWatchInterval
+	Window     time.Duration // Probe window, default is DiscoveryWindow
+
+	init    sync.Once
+	start   sync.Once
+	stop    sync.Once
+	events  chan DeviceEvent
+	done    chan struct{} // Closed by Stop
+	stopped chan struct{} // Closed when the loop is over
+	mutex   sync.Mutex
+	devices map[string]*HPDevice // Key is the device identity
+}
+
+func NewDeviceWatcher(discoverer Discoverer, interval time.Duration) *DeviceWatcher {
+	return &DeviceWatcher{
+		Discoverer: discoverer,
+		Interval:   interval,
+	}
+}
+
+// setup makes the zero DeviceWatcher usable
+func (w *DeviceWatcher) setup() {
+	w.init.Do(func() {
+		w.events = make(chan DeviceEvent, 16)
+		w.done = make(chan struct{})
+		w.stopped = make(chan struct{})
+		w.devices = make(map[string]*HPDevice)
+	})
+}
+
+// Events gives the channel where events are delivered. It's closed when the watcher is stopped.
+func (w *DeviceWatcher) Events() <-chan DeviceEvent {
+	w.setup()
+	return w.events
+}
+
+// Start launches the watch loop. The first probe is done immediately.
+// A watcher is started only once, and can't be started again once stopped.
+func (w *DeviceWatcher) Start() {
+	w.setup()
+	w.start.Do(func() { go w.loop() })
+}
+
+// Stop ends the watch loop and waits it's actually done. It can be called several times.
+func (w *DeviceWatcher) Stop() {
+	w.setup()
+	w.stop.Do(func() { close(w.done) })
+	// Never started, there is no loop to close the channels
+	w.start.Do(func() {
+		close(w.events)
+		close(w.stopped)
+	})
+	<-w.stopped
+}
+
+// Devices returns the devices currently known by the watcher
+func (w *DeviceWatcher) Devices() []*HPDevice {
+	w.setup()
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	devices := make([]*HPDevice, 0, len(w.devices))
+	for _, d := range w.devices {
+		devices = append(devices, d)
+	}
+	return devices
+}
+
+func (w *DeviceWatcher) loop() {
+	defer close(w.stopped)
+	defer close(w.events)
+	interval := w.Interval
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		for _, e := range w.probe() {
+			select {
+			case w.events <- e:
+			case <-w.done:
+				return
+			}
+		}
+		select {
+		case <-tick.C:
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// probe runs one discovery round and computes the events
+func (w *DeviceWatcher) probe() (events []DeviceEvent) {
+	w.setup()
+	discoverer, window := w.Discoverer, w.Window
+	if discoverer == nil {
+		discoverer = DefaultDiscoverer
+	}
+	if window == 0 {
+		window = DiscoveryWindow
+	}
+	found, err := discoverWith(discoverer, window)
+	if err != nil {
+		TRACE.Println("DeviceWatcher.probe", err)
+	}
+
+	w.mutex.Lock()
+	seen := make(map[string]bool)
+	for _, d := range found {
+		id := deviceIdentity(d)
+		seen[id] = true
+		known, ok := w.devices[id]
+		switch {
+		case !ok:
+			w.devices[id] = d
+			events = append(events, DeviceEvent{Type: DeviceAdded, Device: d})
+		case known.IPAddress != d.IPAddress:
+			// A new device, the one given to the caller is kept as it is
+			updated := *known
+			updated.IPAddress, updated.URL, updated.resources = d.IPAddress, d.URL, nil
+			updated.complete(d)
+			w.devices[id] = &updated
+			events = append(events, DeviceEvent{Type: DeviceUpdated, Device: &updated, PreviousIPAddress: known.IPAddress})
+		}
+	}
+	missing := make(map[string]*HPDevice)
+	for id, d := range w.devices {
+		if !seen[id] {
+			missing[id] = d
+		}
+	}
+	w.mutex.Unlock()
+
+	// Devices may ignore discovery and still answer, check them before removal
+	for id, d := range missing {
+		ctx, cancel := context.WithTimeout(context.Background(), EndpointTimeout)
+		err := d.isOnLine(ctx)
+		cancel()
+		if err == nil {
+			continue
+		}
+		w.mutex.Lock()
+		delete(w.devices, id)
+		w.mutex.Unlock()
+		events = append(events, DeviceEvent{Type: DeviceRemoved, Device: d})
+	}
+	return events
+}
+
+// deviceIdentity gives a key that doesn't depend on the device address when possible
+func deviceIdentity(d *HPDevice) string {
+	switch {
+	case d.UUID != "":
+		return "uuid:" + d.UUID
+	case d.SerialNumber != "":
+		return "serial:" + d.SerialNumber
+	}
+	return "ip:" + d.IPAddress
+}
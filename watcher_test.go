@@ -0,0 +1,112 @@
+package hpdevices
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type mutableDiscoverer struct {
+	sync.Mutex
+	devices []HPDevice
+}
+
+func (m *mutableDiscoverer) Probe(time.Duration) (devices []*HPDevice, err error) {
+	m.Lock()
+	defer m.Unlock()
+	for i := range m.devices {
+		d := m.devices[i]
+		devices = append(devices, &d)
+	}
+	return devices, nil
+}
+
+func (m *mutableDiscoverer) set(devices ...HPDevice) {
+	m.Lock()
+	defer m.Unlock()
+	m.devices = devices
+}
+
+func Test_DeviceWatcher(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	discoverer := new(mutableDiscoverer)
+	w := NewDeviceWatcher(discoverer, time.Hour)
+	printer := HPDevice{URL: server.URL, IPAddress: "10.0.0.10", UUID: "1c85", SerialNumber: "CN1"}
+
+	discoverer.set(printer)
+	events := w.probe()
+	if len(events) != 1 || events[0].Type != DeviceAdded {
+		t.Fatalf("expected Added, got %v", events)
+	}
+	added := events[0].Device
+
+	// New DHCP lease
+	printer.IPAddress = "10.0.0.20"
+	discoverer.set(printer)
+	events = w.probe()
+	if len(events) != 1 || events[0].Type != DeviceUpdated || events[0].PreviousIPAddress != "10.0.0.10" || events[0].Device.IPAddress != "10.0.0.20" {
+		t.Fatalf("expected Updated, got %v", events)
+	}
+	if added.IPAddress != "10.0.0.10" || events[0].Device == added {
+		t.Error("device given by the Added event changed")
+	}
+
+	// Still answering HTTP, but not discovery
+	discoverer.set()
+	if events = w.probe(); len(events) != 0 {
+		t.Fatalf("expected no event, got %v", events)
+	}
+
+	// Deep sleep
+	server.Close()
+	if events = w.probe(); len(events) != 1 || events[0].Type != DeviceRemoved {
+		t.Fatalf("expected Removed, got %v", events)
+	}
+	if len(w.Devices()) != 0 {
+		t.Error("removed device still known")
+	}
+}
+
+func Test_DeviceWatcherLoop(t *testing.T) {
+	discoverer := new(mutableDiscoverer)
+	discoverer.set(HPDevice{IPAddress: "10.0.0.10", UUID: "1c85", SerialNumber: "CN1"})
+	w := NewDeviceWatcher(discoverer, time.Hour)
+	w.Start()
+	select {
+	case e := <-w.Events():
+		if e.Type != DeviceAdded {
+			t.Errorf("expected Added, got %v", e.Type)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event from the first probe")
+	}
+	w.Stop()
+	if _, ok := <-w.Events(); ok {
+		t.Error("events channel not closed")
+	}
+	w.Stop()
+}
+
+func Test_DeviceWatcherZero(t *testing.T) {
+	// Stopped before being started
+	w := new(DeviceWatcher)
+	w.Stop()
+	w.Stop()
+	if _, ok := <-w.Events(); ok {
+		t.Error("events channel not closed")
+	}
+
+	// Without interval
+	discoverer := new(mutableDiscoverer)
+	discoverer.set(HPDevice{URL: "http://127.0.0.1:1", IPAddress: "10.0.0.10", UUID: "1c85", SerialNumber: "CN1"})
+	w = &DeviceWatcher{Discoverer: discoverer, Window: time.Millisecond}
+	w.Start()
+	if e := <-w.Events(); e.Type != DeviceAdded {
+		t.Errorf("expected Added, got %v", e.Type)
+	}
+	w.Stop()
+}